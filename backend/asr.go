@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The segment type of openai.AudioResponse, which is an anonymous struct.
+type openaiAudioSegment = struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+	Transient        bool    `json:"transient"`
+}
+
+// AsrResponse is the ASR output, the OpenAI verbose JSON with word-level timestamps.
+type AsrResponse struct {
+	openai.AudioResponse
+	// The words of all segments.
+	Words []AudioWord `json:"words"`
+}
+
+// AsrProvider converts an audio file to text segments and words, the time is relative to the
+// start of the file.
+type AsrProvider interface {
+	Transcribe(ctx context.Context, filename string) (*AsrResponse, error)
+}
+
+// NewAsrProvider create the ASR provider by name, see VODT_ASR_PROVIDER.
+func NewAsrProvider(name string) (AsrProvider, error) {
+	switch name {
+	case "openai":
+		return &openaiAsrProvider{}, nil
+	case "whisper.cpp", "faster-whisper":
+		bin := os.Getenv("VODT_WHISPER_BIN")
+		if bin == "" && name == "whisper.cpp" {
+			bin = "whisper-cli"
+		} else if bin == "" {
+			bin = "whisper-ctranslate2"
+		}
+		return &localAsrProvider{engine: name, bin: bin, model: os.Getenv("VODT_WHISPER_MODEL")}, nil
+	default:
+		return nil, errors.Errorf("Unknown ASR provider %v", name)
+	}
+}
+
+// The ASR by OpenAI Whisper API, the audio is uploaded to OpenAI.
+type openaiAsrProvider struct {
+}
+
+func (v *openaiAsrProvider) Transcribe(ctx context.Context, filename string) (*AsrResponse, error) {
+	// Build the multipart form by ourself, for the timestamp_granularities is not supported by
+	// the openai.AudioRequest, see https://platform.openai.com/docs/api-reference/audio/createTranscription
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, field := range [][]string{
+		{"model", openai.Whisper1},
+		{"response_format", string(openai.AudioResponseFormatVerboseJSON)},
+		{"language", os.Getenv("VODT_ASR_LANGUAGE")},
+		{"timestamp_granularities[]", "word"},
+		{"timestamp_granularities[]", "segment"},
+	} {
+		if err := form.WriteField(field[0], field[1]); err != nil {
+			return nil, errors.Wrapf(err, "write field %v", field[0])
+		}
+	}
+
+	if err := func() error {
+		f, err := os.Open(filename)
+		if err != nil {
+			return errors.Wrapf(err, "open %v", filename)
+		}
+		defer f.Close()
+
+		w, err := form.CreateFormFile("file", path.Base(filename))
+		if err != nil {
+			return errors.Wrapf(err, "create file field")
+		}
+		if _, err = io.Copy(w, f); err != nil {
+			return errors.Wrapf(err, "copy %v", filename)
+		}
+		return nil
+	}(); err != nil {
+		return nil, errors.Wrapf(err, "write file")
+	}
+	if err := form.Close(); err != nil {
+		return nil, errors.Wrapf(err, "close form")
+	}
+
+	url := fmt.Sprintf("%v/audio/transcriptions", strings.TrimSuffix(aiConfig.BaseURL, "/"))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create request %v", url)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", os.Getenv("OPENAI_API_KEY")))
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "transcription")
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read body")
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("transcription failed, status=%v, body is %v", res.StatusCode, string(b))
+	}
+
+	var resp AsrResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+	return &resp, nil
+}
+
+// The ASR by local binary, for example, whisper.cpp or faster-whisper, the audio never leaves
+// the machine.
+type localAsrProvider struct {
+	// The engine, whisper.cpp or faster-whisper.
+	engine string
+	// The binary to execute.
+	bin string
+	// The model name or model file.
+	model string
+}
+
+func (v *localAsrProvider) Transcribe(ctx context.Context, filename string) (*AsrResponse, error) {
+
+	// Convert to 16kHz mono WAV, which is required by whisper.cpp and works for others.
+	wavFile := strings.TrimSuffix(filename, path.Ext(filename)) + ".asr.wav"
+	defer os.Remove(wavFile)
+	if err := exec.CommandContext(ctx, "ffmpeg",
+		"-i", filename,
+		"-vn", "-c:a", "pcm_s16le", "-ac", "1", "-ar", "16000",
+		"-y", wavFile,
+	).Run(); err != nil {
+		return nil, errors.Errorf("Error converting the file %v", wavFile)
+	}
+
+	// The output JSON file of engine.
+	outputPrefix := strings.TrimSuffix(wavFile, path.Ext(wavFile))
+	outputJSON := outputPrefix + ".json"
+	defer os.Remove(outputJSON)
+
+	var args []string
+	if v.engine == "whisper.cpp" {
+		args = []string{
+			"-m", v.model, "-f", wavFile, "-l", os.Getenv("VODT_ASR_LANGUAGE"), "-ojf", "-of", outputPrefix,
+		}
+	} else {
+		args = []string{
+			"--model", v.model, "--language", os.Getenv("VODT_ASR_LANGUAGE"),
+			"--output_format", "json", "--output_dir", path.Dir(wavFile), "--word_timestamps", "True", wavFile,
+		}
+	}
+
+	if b, err := exec.CommandContext(ctx, v.bin, args...).CombinedOutput(); err != nil {
+		return nil, errors.Wrapf(err, "exec %v %v, output is %v", v.bin, strings.Join(args, " "), string(b))
+	}
+	logger.Tf(ctx, "ASR by %v ok, file=%v, output=%v", v.engine, wavFile, outputJSON)
+
+	b, err := ioutil.ReadFile(outputJSON)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read json file %v", outputJSON)
+	}
+
+	resp, err := parseLocalAsrOutput(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse json file %v", outputJSON)
+	}
+	return resp, nil
+}
+
+// Parse the JSON output of local ASR engine, which is the whisper.cpp full format, or the OpenAI
+// whisper format with words in segments for faster-whisper.
+func parseLocalAsrOutput(b []byte) (*AsrResponse, error) {
+	resp := &AsrResponse{}
+
+	// The whisper.cpp format, offsets are in milliseconds.
+	type cppOffsets struct {
+		From int64 `json:"from"`
+		To   int64 `json:"to"`
+	}
+	var cpp struct {
+		Result struct {
+			Language string `json:"language"`
+		} `json:"result"`
+		Transcription []struct {
+			Offsets cppOffsets `json:"offsets"`
+			Text    string     `json:"text"`
+			Tokens  []struct {
+				ID      int        `json:"id"`
+				Text    string     `json:"text"`
+				Offsets cppOffsets `json:"offsets"`
+			} `json:"tokens"`
+		} `json:"transcription"`
+	}
+	if err := json.Unmarshal(b, &cpp); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+
+	if cpp.Transcription == nil {
+		// The OpenAI whisper format, for faster-whisper.
+		var words struct {
+			Segments []struct {
+				Words []AudioWord `json:"words"`
+			} `json:"segments"`
+		}
+		if err := json.Unmarshal(b, &resp.AudioResponse); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", string(b))
+		} else if err := json.Unmarshal(b, &words); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal %v", string(b))
+		}
+
+		for _, s := range words.Segments {
+			resp.Words = append(resp.Words, s.Words...)
+		}
+	} else {
+		resp.Language = cpp.Result.Language
+		for index, t := range cpp.Transcription {
+			segment := openaiAudioSegment{
+				ID:    index,
+				Start: float64(t.Offsets.From) / 1000,
+				End:   float64(t.Offsets.To) / 1000,
+				Text:  t.Text,
+			}
+
+			// The tokens are sub-words, a token starts with space is a new word, and the special
+			// tokens like [_BEG_] are ignored.
+			var words int
+			for _, token := range t.Tokens {
+				if strings.HasPrefix(token.Text, "[_") {
+					continue
+				}
+				segment.Tokens = append(segment.Tokens, token.ID)
+
+				start, end := float64(token.Offsets.From)/1000, float64(token.Offsets.To)/1000
+				if words == 0 || strings.HasPrefix(token.Text, " ") {
+					words++
+					resp.Words = append(resp.Words, AudioWord{Word: strings.TrimSpace(token.Text), Start: start, End: end})
+				} else {
+					word := &resp.Words[len(resp.Words)-1]
+					word.Word += token.Text
+					word.End = end
+				}
+			}
+
+			resp.Segments = append(resp.Segments, segment)
+			resp.Text += t.Text
+		}
+	}
+
+	resp.Task = "transcribe"
+	if resp.Duration <= 0 && len(resp.Segments) > 0 {
+		resp.Duration = resp.Segments[len(resp.Segments)-1].End
+	}
+	if resp.Language == "" {
+		resp.Language = os.Getenv("VODT_ASR_LANGUAGE")
+	}
+	return resp, nil
+}
+
+const (
+	AsrChunkPending = "pending"
+	AsrChunkDone    = "done"
+	AsrChunkFailed  = "failed"
+)
+
+// AsrChunk is a piece of the ASR input audio, which is transcribed independently.
+type AsrChunk struct {
+	// The start time in seconds.
+	Start float64 `json:"start"`
+	// The duration in seconds.
+	Duration float64 `json:"duration"`
+	// The state of chunk, pending, done or failed.
+	State string `json:"state"`
+	// The error of last failure.
+	Error string `json:"error,omitempty"`
+	// The ASR output of chunk, without the main dir.
+	Output string `json:"output,omitempty"`
+}
+
+// AsrSilence is a pause in the ASR input audio, detected by ffmpeg silencedetect.
+type AsrSilence struct {
+	Start float64
+	End   float64
+}
+
+// Detect the silences of audio file, by parsing the logs of ffmpeg silencedetect filter, like:
+//
+//	[silencedetect @ 0x7f8] silence_start: 12.345
+//	[silencedetect @ 0x7f8] silence_end: 13.01 | silence_duration: 0.665
+func detectSilences(ctx context.Context, filename string, duration float64) ([]*AsrSilence, error) {
+	filter := fmt.Sprintf("silencedetect=noise=%v:d=%v",
+		os.Getenv("VODT_ASR_SILENCE_NOISE"), os.Getenv("VODT_ASR_SILENCE_DURATION"))
+	b, err := exec.CommandContext(ctx, "ffmpeg",
+		"-i", filename, "-vn", "-af", filter, "-f", "null", "-",
+	).CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "detect silence of %v", filename)
+	}
+
+	parseValue := func(line, key string) (float64, bool) {
+		index := strings.Index(line, key)
+		if index < 0 {
+			return 0, false
+		}
+		fields := strings.Fields(line[index+len(key):])
+		if len(fields) == 0 {
+			return 0, false
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		return v, err == nil
+	}
+
+	var silences []*AsrSilence
+	var current *AsrSilence
+	for _, line := range strings.Split(string(b), "\n") {
+		if v, ok := parseValue(line, "silence_start:"); ok {
+			current = &AsrSilence{Start: v, End: duration}
+			silences = append(silences, current)
+		} else if v, ok := parseValue(line, "silence_end:"); ok && current != nil {
+			current.End, current = v, nil
+		}
+	}
+	return silences, nil
+}
+
+// Split the audio to chunks no longer than limit, and cut each chunk at the latest silence in
+// the second half of chunk, to avoid cutting words in half. Fallback to the fixed cut if no
+// silence.
+func splitAsrChunks(duration, limit float64, silences []*AsrSilence) []*AsrChunk {
+	var chunks []*AsrChunk
+	for starttime := float64(0); starttime < duration; {
+		boundary := starttime + limit
+		if boundary < duration {
+			for _, silence := range silences {
+				middle := (silence.Start + silence.End) / 2
+				if middle > starttime+limit/2 && middle <= starttime+limit {
+					boundary = middle
+				}
+			}
+		} else {
+			boundary = duration
+		}
+
+		chunks = append(chunks, &AsrChunk{
+			Start: starttime, Duration: boundary - starttime, State: AsrChunkPending,
+		})
+		starttime = boundary
+	}
+	return chunks
+}
+
+// Build the ASR chunks of project, because each ASR is limited to 25MB by OpenAI, see
+// https://platform.openai.com/docs/guides/speech-to-text
+func buildAsrChunks(ctx context.Context, project *Project) ([]*AsrChunk, error) {
+	// Load the duration of input file.
+	duration, bitrate, err := detectInput(ctx, project)
+	if err != nil {
+		return nil, errors.Wrapf(err, "detect input")
+	}
+
+	silences, err := detectSilences(ctx, project.asrInputAudio, duration)
+	if err != nil {
+		return nil, errors.Wrapf(err, "detect silences")
+	}
+
+	limitDuration := int(25*1024*1024*8/float64(bitrate)) / 10
+	chunks := splitAsrChunks(duration, float64(limitDuration), silences)
+	logger.Tf(ctx, "Split ASR input to %v chunks, duration=%v, limit=%v, silences=%v",
+		len(chunks), duration, limitDuration, len(silences))
+	return chunks, nil
+}
+
+// Transcribe a chunk of ASR input audio, and save the output to a JSON file.
+func doAsrChunk(ctx context.Context, project *Project, provider AsrProvider, chunk *AsrChunk) (string, error) {
+	tmpAsrInputAudio := path.Join(project.MainDir, fmt.Sprintf("input-%v.m4a", chunk.Start))
+	defer os.Remove(tmpAsrInputAudio)
+
+	if err := exec.CommandContext(ctx, "ffmpeg",
+		"-i", project.asrInputAudio,
+		"-ss", fmt.Sprintf("%v", chunk.Start), "-t", fmt.Sprintf("%v", chunk.Duration),
+		"-c", "copy", "-y", tmpAsrInputAudio,
+	).Run(); err != nil {
+		return "", errors.Errorf("Error converting the file %v", tmpAsrInputAudio)
+	}
+	logger.Tf(ctx, "Convert to segment %v ok, starttime=%v", tmpAsrInputAudio, chunk.Start)
+
+	// Do ASR, convert to text.
+	resp, err := provider.Transcribe(ctx, tmpAsrInputAudio)
+	if err != nil {
+		return "", errors.Wrapf(err, "transcription")
+	}
+	logger.Tf(ctx, "ASR ok, project=%v, starttime=%v, resp is <%v>B, segments=%v",
+		project.SID, chunk.Start, len(resp.Text), len(resp.Segments))
+
+	output := fmt.Sprintf("asr-%v.json", chunk.Start)
+	if b, err := json.Marshal(resp); err != nil {
+		return "", errors.Wrapf(err, "marshal")
+	} else if err = os.WriteFile(path.Join(project.MainDir, output), b, os.FileMode(0644)); err != nil {
+		return "", errors.Wrapf(err, "write json file %v", output)
+	}
+
+	return output, nil
+}
+
+// Transcribe all chunks which are not done by a pool of workers, and stitch the outputs to the
+// ASR output object in time order when all chunks are done. The state of chunks is saved in
+// project, so it resumes from the failed chunks.
+func doAsr(ctx context.Context, project *Project, job *Job) error {
+	if len(project.AsrChunks) == 0 {
+		chunks, err := buildAsrChunks(ctx, project)
+		if err != nil {
+			return errors.Wrapf(err, "build chunks")
+		}
+
+		project.lock.Lock()
+		project.AsrChunks = chunks
+		err = project.Save()
+		project.lock.Unlock()
+		if err != nil {
+			return errors.Wrapf(err, "save project")
+		}
+	}
+
+	provider, err := NewAsrProvider(os.Getenv("VODT_ASR_PROVIDER"))
+	if err != nil {
+		return errors.Wrapf(err, "asr provider")
+	}
+
+	workers, err := strconv.Atoi(os.Getenv("VODT_ASR_WORKERS"))
+	if err != nil || workers <= 0 {
+		workers = 1
+	}
+
+	var pending []*AsrChunk
+	for _, chunk := range project.AsrChunks {
+		if chunk.State != AsrChunkDone {
+			pending = append(pending, chunk)
+		}
+	}
+	job.SetTotal(len(project.AsrChunks), len(project.AsrChunks)-len(pending))
+	job.Logf(ctx, "ASR project=%v, chunks=%v, pending=%v, workers=%v",
+		project.SID, len(project.AsrChunks), len(pending), workers)
+
+	var wg sync.WaitGroup
+	tokens := make(chan bool, workers)
+	for _, chunk := range pending {
+		wg.Add(1)
+		tokens <- true
+		go func(chunk *AsrChunk) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+
+			output, err := doAsrChunk(ctx, project, provider, chunk)
+
+			project.lock.Lock()
+			defer project.lock.Unlock()
+
+			if err != nil {
+				chunk.State, chunk.Error = AsrChunkFailed, err.Error()
+			} else {
+				chunk.State, chunk.Error, chunk.Output = AsrChunkDone, "", output
+			}
+			job.Progress(fmt.Sprintf("%v", chunk.Start), err)
+			job.Logf(ctx, "ASR chunk starttime=%v, duration=%v, err %v", chunk.Start, chunk.Duration, err)
+
+			if err := project.Save(); err != nil {
+				logger.Tf(ctx, "Save project failed, err %+v", err)
+			}
+		}(chunk)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, chunk := range project.AsrChunks {
+		if chunk.State != AsrChunkDone {
+			failed = append(failed, fmt.Sprintf("%v(%v)", chunk.Start, chunk.Error))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("%v/%v chunks failed: %v", len(failed), len(project.AsrChunks), strings.Join(failed, ", "))
+	}
+
+	// Stitch the outputs of chunks in time order.
+	chunks := append([]*AsrChunk{}, project.AsrChunks...)
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Start < chunks[j].Start
+	})
+
+	output := NewAudioResponse()
+	for _, chunk := range chunks {
+		filename := path.Join(project.MainDir, chunk.Output)
+
+		var resp AsrResponse
+		if b, err := ioutil.ReadFile(filename); err != nil {
+			return errors.Wrapf(err, "read json file %v", filename)
+		} else if err = json.Unmarshal(b, &resp); err != nil {
+			return errors.Wrapf(err, "unmarshal json file %v", filename)
+		}
+
+		output.AppendSegment(&resp, chunk.Start)
+	}
+
+	project.lock.Lock()
+	defer project.lock.Unlock()
+
+	project.asrOutputObject = output
+	if err := project.asrOutputObject.Save(project.asrOutputJSON); err != nil {
+		return errors.Wrapf(err, "save")
+	}
+	job.Logf(ctx, "Save ASR output to %v ok, segments=%v", project.asrOutputJSON, len(project.asrOutputObject.Segments))
+
+	// Cleanup the outputs of chunks, which are merged to the ASR output.
+	for _, chunk := range chunks {
+		os.Remove(path.Join(project.MainDir, chunk.Output))
+	}
+
+	return nil
+}
+
+// The ASR stage of project, convert the input to audio only file, then transcribe it.
+func doAsrStage(ctx context.Context, project *Project, inputURL string, job *Job) error {
+	// Convert input to audio only file.
+	if _, err := os.Stat(project.asrInputAudio); err != nil {
+		project.lock.Lock()
+		project.InputURL = inputURL
+		err := project.Save()
+		project.lock.Unlock()
+		if err != nil {
+			return errors.Wrapf(err, "save project")
+		}
+
+		inputFile, err := resolveInputFile(inputURL)
+		if err != nil {
+			return errors.Wrapf(err, "input")
+		}
+
+		if err := exec.CommandContext(ctx, "ffmpeg",
+			"-i", inputFile,
+			"-vn", "-c:a", "aac", "-ac", "1", "-ar", "16000", "-ab", "50k",
+			project.asrInputAudio,
+		).Run(); err != nil {
+			return errors.Errorf("Error converting the file")
+		}
+		job.Logf(ctx, "Convert to audio %v ok", project.asrInputAudio)
+	}
+
+	// Transcribe the chunks which are not done, resume from the last failure.
+	if err := doAsr(ctx, project, job); err != nil {
+		return errors.Wrapf(err, "asr")
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSplitAsrChunks(t *testing.T) {
+	// Cut at the latest silence in the second half of chunk.
+	chunks := splitAsrChunks(250, 100, []*AsrSilence{
+		{Start: 30, End: 31}, {Start: 70, End: 72}, {Start: 90, End: 92}, {Start: 185, End: 187},
+	})
+	expect := [][2]float64{{0, 91}, {91, 95}, {186, 64}}
+	if len(chunks) != len(expect) {
+		t.Fatalf("chunks %v, expect %v", len(chunks), len(expect))
+	}
+	for i, chunk := range chunks {
+		if chunk.Start != expect[i][0] || chunk.Duration != expect[i][1] || chunk.State != AsrChunkPending {
+			t.Errorf("chunk %v is %v+%v %v, expect %v+%v", i, chunk.Start, chunk.Duration, chunk.State,
+				expect[i][0], expect[i][1])
+		}
+	}
+
+	// Fallback to the fixed cut, if no silence.
+	chunks = splitAsrChunks(250, 100, nil)
+	if len(chunks) != 3 || chunks[1].Start != 100 || chunks[2].Duration != 50 {
+		t.Errorf("invalid fixed chunks %+v %+v %+v", chunks[0], chunks[1], chunks[2])
+	}
+
+	// One chunk, if shorter than limit.
+	if chunks = splitAsrChunks(30, 100, nil); len(chunks) != 1 || chunks[0].Duration != 30 {
+		t.Errorf("invalid short chunks %v", len(chunks))
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+const (
+	ExportAudio = "audio"
+	ExportVideo = "video"
+)
+
+const (
+	ExportSubtitleSoft = "soft"
+	ExportSubtitleBurn = "burn"
+)
+
+// ExportOptions is the options to export the project.
+type ExportOptions struct {
+	// The export mode, audio for the dubbed audio only, or video to mux with the input video.
+	Mode string `json:"mode"`
+	// The container of video, mp4 or mkv.
+	Container string `json:"container"`
+	// Whether keep the original audio as the second track of video.
+	KeepOriginal bool `json:"keep_original"`
+	// The ISO 639-2 language of dubbed track, for example, chi.
+	Language string `json:"language"`
+	// The ISO 639-2 language of original track, for example, eng.
+	OriginalLanguage string `json:"original_language"`
+	// The subtitle of video, empty for none, soft for a subtitle track, or burn into the picture.
+	Subtitle string `json:"subtitle"`
+	// The text of subtitle, source, translated or bilingual.
+	SubtitleMode string `json:"subtitle_mode"`
+	// Whether mix the dub over the original audio, which is ducked during speech.
+	Mix bool `json:"mix"`
+	// The volume of original audio during speech in dB, for example, -15.
+	DuckLevel float64 `json:"duck_level"`
+	// The fade time in seconds to duck and restore the original audio.
+	Fade float64 `json:"fade"`
+}
+
+// The ISO 639-2 language of subtitle track, by the text of subtitle, and mul for bilingual.
+func (v *ExportOptions) SubtitleLanguage() string {
+	switch v.SubtitleMode {
+	case SubtitleSource:
+		return v.OriginalLanguage
+	case SubtitleBilingual:
+		return "mul"
+	default:
+		return v.Language
+	}
+}
+
+// Resolve the input URL to the local file, the resources are in the static directory.
+func resolveInputFile(inputURL string) (string, error) {
+	inputFile := inputURL
+	if strings.HasPrefix(inputFile, "/api/vod-translator/resources/") {
+		inputFile = path.Join("static", inputFile[len("/api/vod-translator/resources/"):])
+	}
+	if _, err := os.Stat(inputFile); err != nil {
+		return "", errors.Wrapf(err, "no file %v", inputFile)
+	}
+	return inputFile, nil
+}
+
+// Export the project by options, the result of job is the exported filename.
+func doExport(ctx context.Context, stage *Project, job *Job, opts *ExportOptions) error {
+	aacFilename, err := doExportAudio(ctx, stage, job)
+	if err != nil {
+		return errors.Wrapf(err, "export audio")
+	}
+
+	if opts.Mix {
+		if aacFilename, err = doExportMix(ctx, stage, job, opts); err != nil {
+			return errors.Wrapf(err, "export mix")
+		}
+	}
+
+	if opts.Mode != ExportVideo {
+		job.SetResult(aacFilename)
+		return nil
+	}
+
+	videoFilename, err := doExportVideo(ctx, stage, job, opts, aacFilename)
+	if err != nil {
+		return errors.Wrapf(err, "export video")
+	}
+	job.SetResult(videoFilename)
+	return nil
+}
+
+// Build the volume expression of ffmpeg to duck the original audio during the speech, with the
+// duck level in dB and the fade time in seconds. The close speeches are merged to one range.
+func buildDuckVolume(segments []*AudioSegment, level, fade float64) string {
+	if fade < 0.001 {
+		fade = 0.001
+	}
+
+	var ranges [][2]float64
+	for _, segment := range segments {
+		if segment.Removed || segment.TTS == "" || segment.End <= segment.Start {
+			continue
+		}
+		if n := len(ranges); n > 0 && segment.Start-ranges[n-1][1] <= 2*fade {
+			if segment.End > ranges[n-1][1] {
+				ranges[n-1][1] = segment.End
+			}
+			continue
+		}
+		ranges = append(ranges, [2]float64{segment.Start, segment.End})
+	}
+	if len(ranges) == 0 {
+		return "1"
+	}
+
+	// The weight of each range is 1 during speech, and ramps in the fade time before and after it.
+	var weights []string
+	for _, r := range ranges {
+		weights = append(weights, fmt.Sprintf("clip((t-%.3f)/%.3f,0,1)*clip((%.3f-t)/%.3f,0,1)",
+			r[0]-fade, fade, r[1]+fade, fade,
+		))
+	}
+
+	duck := math.Pow(10, level/20)
+	return fmt.Sprintf("1-%.4f*min(1,%v)", 1-duck, strings.Join(weights, "+"))
+}
+
+// The bed audio of project to mix under the dub, the accompaniment if separated, or the original
+// audio of input.
+func exportBedFile(stage *Project) (string, error) {
+	if accompanimentFile := queryAccompaniment(stage); accompanimentFile != "" {
+		return accompanimentFile, nil
+	}
+	return resolveInputFile(stage.InputURL)
+}
+
+// Mix the dubbed audio over the original audio, which is ducked during speech, returns the mixed
+// filename.
+func doExportMix(ctx context.Context, stage *Project, job *Job, opts *ExportOptions) (string, error) {
+	bedFile, err := exportBedFile(stage)
+	if err != nil {
+		return "", errors.Wrapf(err, "bed")
+	}
+
+	// The volume expression is long, so write the filter graph to a script file.
+	volume := buildDuckVolume(stage.CopySegments(), opts.DuckLevel, opts.Fade)
+	graph := fmt.Sprintf("[0:a]aresample=44100,aformat=channel_layouts=stereo,volume='%v':eval=frame[bed];"+
+		"[1:a]aresample=44100,aformat=channel_layouts=stereo[dub];"+
+		"[bed][dub]amix=inputs=2:duration=first:normalize=0[out]", volume,
+	)
+	graphFile := path.Join(stage.MainDir, fmt.Sprintf("mix-%v.txt", stage.SID))
+	if err := os.WriteFile(graphFile, []byte(graph), os.FileMode(0644)); err != nil {
+		return "", errors.Wrapf(err, "write %v", graphFile)
+	}
+
+	mixFilename := fmt.Sprintf("audio-%v-mix.mp4", stage.SID)
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-i", bedFile, "-i", path.Join(stage.MainDir, fmt.Sprintf("audio-%v.wav", stage.SID)),
+		"-filter_complex_script", graphFile, "-map", "[out]",
+		"-vn", "-c:a", "aac", "-ac", "2", "-ar", "44100", "-ab", "160k",
+		"-y", path.Join(stage.MainDir, mixFilename),
+	)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return "", errors.Wrapf(err, "exec %v, output is %v", cmd.String(), string(b))
+	}
+	job.Logf(ctx, "Mix audio %v ok, bed=%v, duck=%vdB, fade=%vs", mixFilename, bedFile, opts.DuckLevel, opts.Fade)
+	return mixFilename, nil
+}
+
+// Mux the dubbed audio with the video stream of input, and the original audio if keep it.
+func doExportVideo(ctx context.Context, stage *Project, job *Job, opts *ExportOptions, aacFilename string) (string, error) {
+	inputFile, err := resolveInputFile(stage.InputURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "input")
+	}
+
+	videoFilename := fmt.Sprintf("video-%v.%v", stage.SID, opts.Container)
+	args := []string{
+		"-i", inputFile, "-i", path.Join(stage.MainDir, aacFilename),
+	}
+
+	// Write the subtitle file, the soft subtitle is the third input.
+	var subtitleFile string
+	if opts.Subtitle == ExportSubtitleSoft {
+		format := SubtitleSRT
+		if opts.Container == "mkv" {
+			format = SubtitleVTT
+		}
+		stage.lock.Lock()
+		subtitle, err := stage.asrOutputObject.Subtitle(format, opts.SubtitleMode)
+		stage.lock.Unlock()
+		if err != nil {
+			return "", errors.Wrapf(err, "subtitle")
+		}
+
+		subtitleFile = path.Join(stage.MainDir, fmt.Sprintf("subtitle-%v.%v", stage.SID, format))
+		if err := os.WriteFile(subtitleFile, []byte(subtitle), os.FileMode(0644)); err != nil {
+			return "", errors.Wrapf(err, "write %v", subtitleFile)
+		}
+		args = append(args, "-i", subtitleFile)
+	} else if opts.Subtitle == ExportSubtitleBurn {
+		stage.lock.Lock()
+		subtitle, err := stage.asrOutputObject.ASS(opts.SubtitleMode, stage.QuerySubtitleStyle())
+		stage.lock.Unlock()
+		if err != nil {
+			return "", errors.Wrapf(err, "ass")
+		}
+
+		subtitleFile = path.Join(stage.MainDir, fmt.Sprintf("subtitle-%v.ass", stage.SID))
+		if err := os.WriteFile(subtitleFile, []byte(subtitle), os.FileMode(0644)); err != nil {
+			return "", errors.Wrapf(err, "write %v", subtitleFile)
+		}
+	}
+
+	args = append(args, "-map", "0:v:0", "-map", "1:a:0")
+	if opts.KeepOriginal {
+		args = append(args, "-map", "0:a:0?")
+	}
+	if opts.Subtitle == ExportSubtitleSoft {
+		args = append(args, "-map", "2:s:0")
+	}
+
+	// The burned subtitle requires transcoding the video, or else copy it.
+	if opts.Subtitle == ExportSubtitleBurn {
+		// The filename is quoted for the filter graph, and escaped for the filter option.
+		filename := strings.NewReplacer("\\", "\\\\", ":", "\\:").Replace(subtitleFile)
+		args = append(args,
+			"-vf", fmt.Sprintf("ass='%v'", filename),
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "20",
+		)
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+
+	args = append(args,
+		"-c:a:0", "copy",
+		"-metadata:s:a:0", fmt.Sprintf("language=%v", opts.Language), "-metadata:s:a:0", "title=Dubbed",
+		"-disposition:a:0", "default",
+	)
+	if opts.KeepOriginal {
+		args = append(args,
+			"-c:a:1", "aac",
+			"-metadata:s:a:1", fmt.Sprintf("language=%v", opts.OriginalLanguage), "-metadata:s:a:1", "title=Original",
+			"-disposition:a:1", "0",
+		)
+	}
+	if opts.Subtitle == ExportSubtitleSoft {
+		codec := "mov_text"
+		if opts.Container == "mkv" {
+			codec = "webvtt"
+		}
+		args = append(args, "-c:s", codec, "-metadata:s:s:0", fmt.Sprintf("language=%v", opts.SubtitleLanguage()))
+	}
+	if opts.Container == "mp4" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, "-y", path.Join(stage.MainDir, videoFilename))
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return "", errors.Wrapf(err, "exec %v, output is %v", cmd.String(), string(b))
+	}
+	job.Logf(ctx, "Mux video %v ok, keep original=%v, subtitle=%v", videoFilename, opts.KeepOriginal, opts.Subtitle)
+	return videoFilename, nil
+}
+
+// The sample rate of exported audio, mono and 16 bits.
+const exportSampleRate = 44100
+
+// The streaming WAV writer, writes the PCM to file and patches the header when close, so the
+// memory is constant whatever the duration.
+type wavWriter struct {
+	// The WAV file.
+	f *os.File
+	// The number of samples written.
+	samples int64
+	// The buffer to copy PCM and write silence.
+	buf []byte
+}
+
+func newWavWriter(filename string) (*wavWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create %v", filename)
+	}
+
+	// Write the header with zero size, which is patched when close.
+	v := &wavWriter{f: f, buf: make([]byte, 64*1024)}
+	if err := v.writeHeader(); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "write header")
+	}
+	return v, nil
+}
+
+func (v *wavWriter) writeHeader() error {
+	size := uint32(v.samples * 2)
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 36+size)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], 1) // Mono
+	binary.LittleEndian.PutUint32(header[24:], exportSampleRate)
+	binary.LittleEndian.PutUint32(header[28:], exportSampleRate*2)
+	binary.LittleEndian.PutUint16(header[32:], 2)
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], size)
+
+	if _, err := v.f.WriteAt(header, 0); err != nil {
+		return errors.Wrapf(err, "write")
+	}
+	return nil
+}
+
+// Position returns the duration written in seconds.
+func (v *wavWriter) Position() float64 {
+	return float64(v.samples) / exportSampleRate
+}
+
+// WriteSilence write the silence until the position in seconds, ignore if less than 10ms.
+func (v *wavWriter) WriteSilence(at float64) error {
+	samples := int64(at*exportSampleRate) - v.samples
+	if samples < exportSampleRate/100 {
+		return nil
+	}
+
+	for i := range v.buf {
+		v.buf[i] = 0
+	}
+	for left := samples * 2; left > 0; {
+		n := int64(len(v.buf))
+		if n > left {
+			n = left
+		}
+		if _, err := v.f.WriteAt(v.buf[:n], 44+v.samples*2); err != nil {
+			return errors.Wrapf(err, "write")
+		}
+		v.samples, left = v.samples+n/2, left-n
+	}
+	return nil
+}
+
+// WriteFrom copy the 16 bits mono PCM from reader, returns the duration in seconds.
+func (v *wavWriter) WriteFrom(r io.Reader) (float64, error) {
+	var written int64
+	for {
+		// The buffer is even, so only the last read may be odd, the odd byte is dropped.
+		n, err := io.ReadFull(r, v.buf)
+		if n -= n % 2; n > 0 {
+			if _, err := v.f.WriteAt(v.buf[:n], 44+v.samples*2); err != nil {
+				return 0, errors.Wrapf(err, "write")
+			}
+			v.samples, written = v.samples+int64(n/2), written+int64(n/2)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return 0, errors.Wrapf(err, "read")
+		}
+	}
+	return float64(written) / exportSampleRate, nil
+}
+
+// Close patch the header and close the file, it's safe to close more than once.
+func (v *wavWriter) Close() error {
+	if v.f == nil {
+		return nil
+	}
+
+	f := v.f
+	defer func() {
+		v.f = nil
+	}()
+
+	if err := v.writeHeader(); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "write header")
+	}
+	return f.Close()
+}
+
+// Decode the audio file to PCM at the export sample rate by ffmpeg, and write at most limit seconds
+// to WAV, no limit if infinity. Returns the duration written, and the duration which is trimmed.
+func writeClip(ctx context.Context, w *wavWriter, filename string, limit float64) (float64, float64, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-i", filename,
+		"-vn", "-f", "s16le", "-c:a", "pcm_s16le", "-ac", "1", "-ar", fmt.Sprintf("%v", exportSampleRate),
+		"pipe:1",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "pipe")
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, 0, errors.Wrapf(err, "start %v", cmd.String())
+	}
+
+	var r io.Reader = stdout
+	if !math.IsInf(limit, 1) {
+		r = &io.LimitedReader{R: stdout, N: int64(limit*exportSampleRate) * 2}
+	}
+	duration, err := w.WriteFrom(r)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, 0, errors.Wrapf(err, "write %v", filename)
+	}
+
+	// Drain the PCM out of the limit, to measure the overflow.
+	trimmed, err := io.Copy(io.Discard, stdout)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, 0, errors.Wrapf(err, "read %v", filename)
+	}
+	if err := cmd.Wait(); err != nil {
+		return 0, 0, errors.Wrapf(err, "exec %v", cmd.String())
+	}
+	return duration, float64(trimmed/2) / exportSampleRate, nil
+}
+
+// Export the dubbed audio of project, returns the exported filename. The TTS clips are decoded and
+// streamed to a WAV at the final sample rate, with silence to align each clip to its segment.
+func doExportAudio(ctx context.Context, stage *Project, job *Job) (string, error) {
+	audioFilename := fmt.Sprintf("audio-%v.wav", stage.SID)
+	audioFile := path.Join(stage.MainDir, audioFilename)
+
+	w, err := newWavWriter(audioFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "create %v", audioFile)
+	}
+	defer w.Close()
+
+	// Export the copies of segments, to allow changing the segments while exporting.
+	segments := stage.CopySegments()
+	for index, segment := range segments {
+		job.SetPercent(float64(index) * 90 / float64(len(segments)))
+		logger.Tf(ctx, "Handle segment %v, time %v~%v", segment.UUID, segment.Start, segment.End)
+
+		// Pad silence to the start of segment, so that the audio is aligned to the video. The clip
+		// is trimmed at the next segment, so it never delays the next segment.
+		if err := w.WriteSilence(segment.Start); err != nil {
+			return "", errors.Wrapf(err, "insert silent to %v", segment.Start)
+		}
+
+		if segment.TTS == "" || segment.Removed {
+			if err := w.WriteSilence(segment.End); err != nil {
+				return "", errors.Wrapf(err, "insert silent to %v", segment.End)
+			}
+			continue
+		}
+
+		// Fit the TTS to the slot, if not fitted or converted again.
+		if shouldFit(segment) {
+			if err := doFit(ctx, stage, segment); err != nil {
+				return "", errors.Wrapf(err, "fit %v", segment.UUID)
+			}
+			if err := saveFit(stage, segment); err != nil {
+				return "", errors.Wrapf(err, "save")
+			}
+		}
+
+		ttsFile := path.Join(stage.MainDir, segment.TTS)
+		if segment.Fitted != "" {
+			ttsFile = path.Join(stage.MainDir, segment.Fitted)
+		}
+
+		// The clip may overflow to the silence before the next segment, or to the end of audio, and
+		// is trimmed beyond that, so that the next segment still starts at its time.
+		limit := math.Inf(1)
+		for _, next := range segments[index+1:] {
+			if !next.Removed {
+				limit = next.Start - w.Position()
+				break
+			}
+		}
+
+		duration, trimmed, err := writeClip(ctx, w, ttsFile, limit)
+		if err != nil {
+			return "", errors.Wrapf(err, "merge")
+		}
+		logger.Tf(ctx, "Write wav ok, duration=%v, data=%.3f", segment.TTSDuration, duration)
+
+		if overflow := w.Position() - segment.End; overflow >= 0.01 || trimmed >= 0.01 {
+			job.Logf(ctx, "Segment %v overflows %.3fs, trimmed %.3fs, tempo=%v, should be shorter",
+				segment.UUID, math.Max(overflow, 0)+trimmed, trimmed, segment.FitTempo)
+		}
+		if err := w.WriteSilence(segment.End); err != nil {
+			return "", errors.Wrapf(err, "insert silent to %v", segment.End)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", errors.Wrapf(err, "close %v", audioFile)
+	}
+	job.Logf(ctx, "All segments are converted, duration=%.3f", w.Position())
+
+	aacFilename := fmt.Sprintf("audio-%v.mp4", stage.SID)
+	aacFile := path.Join(stage.MainDir, aacFilename)
+	if true {
+		if err := exec.CommandContext(ctx, "ffmpeg",
+			"-i", audioFile,
+			"-vn", "-c:a", "aac", "-ac", "2", "-ar", "44100", "-ab", "120k",
+			"-y", aacFile,
+		).Run(); err != nil {
+			return "", errors.Errorf("Error converting the file")
+		}
+		logger.Tf(ctx, "Convert to aac %v ok", aacFile)
+	}
+	return aacFilename, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDuckVolume(t *testing.T) {
+	if v := buildDuckVolume(nil, -15, 0.3); v != "1" {
+		t.Errorf("no speech should be 1, got %v", v)
+	}
+
+	// The close speeches are merged, the removed or not converted segments are ignored.
+	v := buildDuckVolume([]*AudioSegment{
+		{Start: 1, End: 2, TTS: "a"}, {Start: 2.5, End: 3, TTS: "b"}, {Start: 5, End: 6, TTS: "c", Removed: true},
+		{Start: 8, End: 9}, {Start: 10, End: 11, TTS: "d"},
+	}, -20, 0.3)
+	expect := "1-0.9000*min(1,clip((t-0.700)/0.300,0,1)*clip((3.300-t)/0.300,0,1)+" +
+		"clip((t-9.700)/0.300,0,1)*clip((11.300-t)/0.300,0,1))"
+	if v != expect {
+		t.Errorf("got %v, expect %v", v, expect)
+	}
+
+	// The zero fade is clamped, to avoid dividing by zero.
+	if v := buildDuckVolume([]*AudioSegment{{Start: 1, End: 2, TTS: "a"}}, -15, 0); strings.Contains(v, "/0.000") {
+		t.Errorf("zero fade in %v", v)
+	}
+}
+
+// The reader of zero PCM, without allocation.
+func TestExportSubtitleLanguage(t *testing.T) {
+	for mode, expect := range map[string]string{
+		SubtitleTranslated: "chi", SubtitleSource: "eng", SubtitleBilingual: "mul",
+	} {
+		opts := ExportOptions{Language: "chi", OriginalLanguage: "eng", SubtitleMode: mode}
+		if v := opts.SubtitleLanguage(); v != expect {
+			t.Errorf("subtitle %v language is %v, expect %v", mode, v, expect)
+		}
+	}
+}
+
+type zeroReader struct{}
+
+func (v zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Write the WAV of the duration, by clips of 3s with 2s silence between, like the export. The
+// allocations per op should be the same whatever the duration, because the PCM is streamed.
+func BenchmarkWavWriter(b *testing.B) {
+	for _, duration := range []time.Duration{time.Minute, 10 * time.Minute, 60 * time.Minute} {
+		b.Run(duration.String(), func(b *testing.B) {
+			filename := path.Join(b.TempDir(), "audio.wav")
+			b.ReportAllocs()
+			b.SetBytes(int64(duration.Seconds() * exportSampleRate * 2))
+
+			for i := 0; i < b.N; i++ {
+				w, err := newWavWriter(filename)
+				if err != nil {
+					b.Fatal(err)
+				}
+				clip := &io.LimitedReader{R: zeroReader{}}
+				for at := 0.0; at < duration.Seconds(); at += 5 {
+					if err := w.WriteSilence(at + 2); err != nil {
+						b.Fatal(err)
+					}
+					clip.N = 3 * exportSampleRate * 2
+					if _, err := w.WriteFrom(clip); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := w.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Decode the clip of the duration by writeClip, like the export of long TTS, trimmed at the half
+// or not. The allocations per op should be the same whatever the duration, because the PCM is
+// streamed from ffmpeg to the WAV.
+func BenchmarkWriteClip(b *testing.B) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		b.Skip("no ffmpeg")
+	}
+
+	for _, duration := range []time.Duration{time.Minute, 10 * time.Minute, 60 * time.Minute} {
+		clipFile := path.Join(b.TempDir(), "clip.wav")
+		if w, err := newWavWriter(clipFile); err != nil {
+			b.Fatal(err)
+		} else if err := w.WriteSilence(duration.Seconds()); err != nil {
+			b.Fatal(err)
+		} else if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+
+		for _, limit := range []float64{math.Inf(1), duration.Seconds() / 2} {
+			b.Run(fmt.Sprintf("%v/limit=%v", duration, limit), func(b *testing.B) {
+				filename := path.Join(b.TempDir(), "audio.wav")
+				b.ReportAllocs()
+				b.SetBytes(int64(duration.Seconds() * exportSampleRate * 2))
+
+				for i := 0; i < b.N; i++ {
+					w, err := newWavWriter(filename)
+					if err != nil {
+						b.Fatal(err)
+					}
+					written, trimmed, err := writeClip(context.Background(), w, clipFile, limit)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if math.Abs(written+trimmed-duration.Seconds()) > 0.1 {
+						b.Fatalf("written %v, trimmed %v, expect %v", written, trimmed, duration)
+					}
+					if err := w.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Whether the TTS of segment should be fitted to the slot, if converted after the last fitting.
+func shouldFit(target *AudioSegment) bool {
+	if target.Removed || target.TTS == "" || target.TTSDuration <= 0 {
+		return false
+	}
+	return time.Time(target.FittedAt).IsZero() || time.Time(target.TTSAt).After(time.Time(target.FittedAt))
+}
+
+// Parse the tempo bounds by VODT_FIT_MIN_TEMPO and VODT_FIT_MAX_TEMPO.
+func fitTempoBounds() (float64, float64, error) {
+	minTempo, err := strconv.ParseFloat(os.Getenv("VODT_FIT_MIN_TEMPO"), 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "parse VODT_FIT_MIN_TEMPO %v", os.Getenv("VODT_FIT_MIN_TEMPO"))
+	}
+	maxTempo, err := strconv.ParseFloat(os.Getenv("VODT_FIT_MAX_TEMPO"), 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "parse VODT_FIT_MAX_TEMPO %v", os.Getenv("VODT_FIT_MAX_TEMPO"))
+	}
+	if minTempo < 0.5 || minTempo > 1 || maxTempo < 1 || maxTempo > 4 {
+		return 0, 0, errors.Errorf("invalid tempo %v~%v, should in 0.5~1~4", minTempo, maxTempo)
+	}
+	return minTempo, maxTempo, nil
+}
+
+// Build the ffmpeg atempo filters, chained because each atempo is limited to 0.5~2.0.
+func buildAtempoFilters(tempo float64) string {
+	var filters []string
+	for tempo > 2.0 {
+		filters = append(filters, "atempo=2.0")
+		tempo /= 2.0
+	}
+	return strings.Join(append(filters, fmt.Sprintf("atempo=%.4f", tempo)), ",")
+}
+
+// Fit the TTS of segment to the slot of segment, by time-stretching the TTS with tempo in the
+// bounds. The segment is flagged as overflow, if the TTS is still longer than the slot, which
+// should be translated shorter.
+func doFit(ctx context.Context, stage *Project, target *AudioSegment) error {
+	minTempo, maxTempo, err := fitTempoBounds()
+	if err != nil {
+		return errors.Wrapf(err, "bounds")
+	}
+
+	slot := target.End - target.Start
+	if slot <= 0 {
+		return errors.Errorf("invalid slot %v~%v of %v", target.Start, target.End, target.UUID)
+	}
+
+	tempo := target.TTSDuration / slot
+	target.Overflow = tempo > maxTempo
+	if tempo > maxTempo {
+		tempo = maxTempo
+	} else if tempo < minTempo {
+		tempo = minTempo
+	}
+
+	// Use the TTS file directly, if no need to stretch.
+	if tempo > 0.99 && tempo < 1.01 {
+		target.Fitted, target.FitTempo, target.FittedAt = "", 1, AITime(time.Now())
+		logger.Tf(ctx, "Fit %v ok, slot=%.3f, tts=%.3f, no stretch", target.UUID, slot, target.TTSDuration)
+		return nil
+	}
+
+	fittedFilename := fmt.Sprintf("fit-%v%v", target.UUID, path.Ext(target.TTS))
+	fittedFile := path.Join(stage.MainDir, fittedFilename)
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-i", path.Join(stage.MainDir, target.TTS),
+		"-vn", "-filter:a", buildAtempoFilters(tempo),
+		"-y", fittedFile,
+	)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "exec %v, output is %v", cmd.String(), string(b))
+	}
+
+	target.Fitted, target.FitTempo, target.FittedAt = fittedFilename, tempo, AITime(time.Now())
+	logger.Tf(ctx, "Fit %v ok, slot=%.3f, tts=%.3f, tempo=%.3f, overflow=%v, file=%v",
+		target.UUID, slot, target.TTSDuration, tempo, target.Overflow, fittedFilename)
+	return nil
+}
+
+// Apply the fitting of the copy of segment to the segment in project, and save the ASR output.
+func saveFit(stage *Project, fitted *AudioSegment) error {
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	// Ignore if the segment is removed by merge or split while fitting.
+	target := stage.asrOutputObject.QuerySegment(fitted.UUID)
+	if target == nil {
+		return nil
+	}
+
+	target.Fitted, target.FitTempo, target.FittedAt = fitted.Fitted, fitted.FitTempo, fitted.FittedAt
+	target.Overflow = fitted.Overflow
+	return stage.asrOutputObject.Save(stage.asrOutputJSON)
+}
+
+// Fit all segments to their slots, each segment is saved once fitted.
+func doFitAll(ctx context.Context, stage *Project, job *Job, segments []*AudioSegment) error {
+	var wg sync.WaitGroup
+	tokens := make(chan bool, 3)
+	for _, segment := range segments {
+		wg.Add(1)
+		tokens <- true
+		go func(target *AudioSegment) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+
+			tmp, err := processSegmentCopy(stage, target.UUID, func(tmp *AudioSegment) error {
+				return doFit(ctx, stage, tmp)
+			}, func(target, tmp *AudioSegment) {
+				target.Fitted, target.FitTempo, target.FittedAt = tmp.Fitted, tmp.FitTempo, tmp.FittedAt
+				target.Overflow = tmp.Overflow
+			})
+
+			job.Progress(target.UUID, err)
+			job.Logf(ctx, "Fit %v, tempo=%v, overflow=%v, err %v", target.UUID, tmp.FitTempo, tmp.Overflow, err)
+		}(segment)
+	}
+	wg.Wait()
+
+	return nil
+}
@@ -3,11 +3,8 @@ module server
 go 1.18
 
 require (
-	github.com/go-audio/audio v1.0.0 // indirect
-	github.com/go-audio/riff v1.0.0 // indirect
-	github.com/go-audio/wav v1.1.0 // indirect
-	github.com/google/uuid v1.5.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/ossrs/go-oryx-lib v0.0.9 // indirect
-	github.com/sashabaranov/go-openai v1.17.9 // indirect
+	github.com/google/uuid v1.5.0
+	github.com/joho/godotenv v1.5.1
+	github.com/ossrs/go-oryx-lib v0.0.9
+	github.com/sashabaranov/go-openai v1.17.9
 )
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a long running operation of project, for example, ASR or translate all segments, which
+// runs in background, reports the progress and is saved in the project directory.
+type Job struct {
+	// The job ID.
+	ID string `json:"id"`
+	// The project SID.
+	SID string `json:"sid"`
+	// The kind of job, for example, asr, translate, tts or export.
+	Kind string `json:"kind"`
+	// The state of job, running, done or failed.
+	State string `json:"state"`
+	// The progress in percentage, 0 to 100.
+	Percent float64 `json:"percent"`
+	// The number of items to process.
+	Total int `json:"total"`
+	// The number of items processed ok.
+	Done int `json:"done"`
+	// The number of items failed.
+	Failed int `json:"failed"`
+	// The errors of failed items, the key is the item, for example, the segment UUID.
+	Errors map[string]string `json:"errors"`
+	// The error of job, if failed.
+	Error string `json:"error,omitempty"`
+	// The result of job, for example, the exported filename.
+	Result string `json:"result,omitempty"`
+	// The create time.
+	Created AITime `json:"created"`
+	// The update time.
+	Update AITime `json:"update"`
+	// The manager to publish events.
+	manager *JobManager
+	// The directory to save job.
+	dir string
+	// Closed when job is finished.
+	done chan bool
+	// The state saved to file, to only save the job when state changes.
+	saved string
+	// The lock to protect fields.
+	lock sync.Mutex
+}
+
+func NewJob(sid, kind string, total int) *Job {
+	return &Job{
+		ID: uuid.NewString(), SID: sid, Kind: kind, State: JobRunning, Total: total,
+		Errors: make(map[string]string), Created: AITime(time.Now()), Update: AITime(time.Now()),
+		done: make(chan bool),
+	}
+}
+
+// SetTotal reset the number of items to process, and the number of items already done.
+func (v *Job) SetTotal(total, done int) {
+	v.update(func() {
+		v.Total, v.Done, v.Failed = total, done, 0
+		v.Errors = make(map[string]string)
+		if total > 0 {
+			v.Percent = float64(done) * 100 / float64(total)
+		}
+	})
+}
+
+// SetPercent set the progress in percentage, for job without items.
+func (v *Job) SetPercent(percent float64) {
+	v.update(func() {
+		v.Percent = percent
+	})
+}
+
+// Progress report an item is done, or failed if err is not nil.
+func (v *Job) Progress(item string, err error) {
+	v.update(func() {
+		if err != nil {
+			v.Failed++
+			v.Errors[item] = err.Error()
+		} else {
+			v.Done++
+			delete(v.Errors, item)
+		}
+		if v.Total > 0 {
+			v.Percent = float64(v.Done+v.Failed) * 100 / float64(v.Total)
+		}
+	})
+}
+
+// SetResult set the result of job, for example, the exported filename.
+func (v *Job) SetResult(result string) {
+	v.update(func() {
+		v.Result = result
+	})
+}
+
+// Logf write a log line, and publish it to the subscribers of project.
+func (v *Job) Logf(ctx context.Context, format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	logger.Tf(ctx, "Job %v %v: %v", v.Kind, v.ID, message)
+
+	if v.manager != nil {
+		v.manager.publish(&JobEvent{Type: "log", SID: v.SID, JobID: v.ID, Message: message})
+	}
+}
+
+// Finish the job, failed if err is not nil or any item failed.
+func (v *Job) finish(err error) {
+	v.update(func() {
+		if err != nil {
+			v.State, v.Error = JobFailed, err.Error()
+		} else if v.Failed > 0 {
+			v.State, v.Error = JobFailed, fmt.Sprintf("%v/%v items failed", v.Failed, v.Total)
+		} else {
+			v.State, v.Percent = JobDone, 100
+		}
+	})
+	close(v.done)
+}
+
+// Wait for the job to finish, returns error if job failed.
+func (v *Job) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-v.done:
+	}
+
+	job := v.Snapshot()
+	if job.State == JobFailed {
+		return errors.Errorf("job %v %v failed, %v", job.Kind, job.ID, job.Error)
+	}
+	return nil
+}
+
+// Update the fields of job, then publish the job. The job is only saved when the state changes,
+// because the progress is updated for each item.
+func (v *Job) update(fn func()) {
+	v.lock.Lock()
+	fn()
+	v.Update = AITime(time.Now())
+	save := v.dir != "" && v.State != v.saved
+	v.saved = v.State
+	v.lock.Unlock()
+
+	if save {
+		if err := v.Save(v.dir); err != nil {
+			logger.Tf(context.Background(), "Save job %v failed, err %+v", v.ID, err)
+		}
+	}
+	if v.manager != nil {
+		v.manager.publish(&JobEvent{Type: "progress", SID: v.SID, JobID: v.ID, Job: v.Snapshot()})
+	}
+}
+
+// Snapshot returns a copy of job, which is safe to marshal.
+func (v *Job) Snapshot() *Job {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	job := &Job{
+		ID: v.ID, SID: v.SID, Kind: v.Kind, State: v.State, Percent: v.Percent, Total: v.Total,
+		Done: v.Done, Failed: v.Failed, Errors: make(map[string]string), Error: v.Error,
+		Result: v.Result, Created: v.Created, Update: v.Update,
+	}
+	for k, e := range v.Errors {
+		job.Errors[k] = e
+	}
+	return job
+}
+
+// The file of job in project directory.
+func buildJobFile(id string) string {
+	return fmt.Sprintf("job-%v.json", id)
+}
+
+// Save the job to file job-<id>.json in dir, to resume the job after restart.
+func (v *Job) Save(dir string) error {
+	filename := path.Join(dir, buildJobFile(v.ID))
+	if b, err := json.Marshal(v.Snapshot()); err != nil {
+		return errors.Wrapf(err, "marshal")
+	} else if err = writeFileAtomic(filename, b, os.FileMode(0644)); err != nil {
+		return errors.Wrapf(err, "write json file %v", filename)
+	}
+	return nil
+}
+
+// Load the jobs from the job-<id>.json files in dir.
+func loadJobs(dir string) ([]*Job, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read dir %v", dir)
+	}
+
+	var jobs []*Job
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "job-") || path.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		filename := path.Join(dir, file.Name())
+		job := &Job{Errors: make(map[string]string), done: make(chan bool)}
+		if b, err := ioutil.ReadFile(filename); err != nil {
+			return nil, errors.Wrapf(err, "read json file %v", filename)
+		} else if err = json.Unmarshal(b, job); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal json file %v", filename)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Process a copy of segment by process without lock, to avoid changing the segment while saving,
+// then apply the result to the segment and save. The segment may be reloaded, undone or removed
+// while processing, so it's always queried by UUID. Returns the processed copy.
+func processSegmentCopy(stage *Project, id string, process func(tmp *AudioSegment) error, apply func(target, tmp *AudioSegment)) (*AudioSegment, error) {
+	stage.lock.Lock()
+	var tmp AudioSegment
+	target := stage.asrOutputObject.QuerySegment(id)
+	if target != nil {
+		tmp = *target
+	}
+	stage.lock.Unlock()
+
+	if target == nil {
+		return &tmp, errors.Errorf("segment %v is removed", id)
+	}
+	if err := process(&tmp); err != nil {
+		return &tmp, err
+	}
+
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	if target = stage.asrOutputObject.QuerySegment(id); target == nil {
+		return &tmp, errors.Errorf("segment %v is removed while processing", id)
+	}
+	apply(target, &tmp)
+	return &tmp, stage.asrOutputObject.Save(stage.asrOutputJSON)
+}
+
+// JobEvent is the event of job, streamed to the subscribers of project.
+type JobEvent struct {
+	// The type of event, progress or log.
+	Type string `json:"type"`
+	// The project SID.
+	SID string `json:"sid"`
+	// The job ID.
+	JobID string `json:"jobId"`
+	// The job, for progress event.
+	Job *Job `json:"job,omitempty"`
+	// The log line, for log event.
+	Message string `json:"message,omitempty"`
+}
+
+// JobManager manages the jobs of all projects, and publishes the events of jobs to subscribers.
+type JobManager struct {
+	// All jobs started by user.
+	jobs []*Job
+	// The subscribers of each project, the key is the project SID.
+	subscribers map[string]map[chan *JobEvent]bool
+	// The SIDs of deleted projects, which never start jobs.
+	deleted map[string]bool
+	// The lock to protect fields.
+	lock sync.Mutex
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{
+		subscribers: make(map[string]map[chan *JobEvent]bool),
+		deleted:     make(map[string]bool),
+	}
+}
+
+// Start a job of project, which runs the fn in background, and saves the job in the project
+// directory. If there is a running job of the same kind, returns it.
+func (v *JobManager) Start(ctx context.Context, stage *Project, kind string, total int, fn func(job *Job) error) *Job {
+	job, reused, err := v.create(stage, kind, total)
+	if reused {
+		logger.Tf(ctx, "Job %v %v is running, reuse it", kind, job.ID)
+		return job
+	}
+
+	// Fail the job immediately if the project is deleted, or exceeds the disk quota.
+	if err == nil {
+		if err = checkDiskQuota(stage); err != nil {
+			err = errors.Wrapf(err, "quota")
+		}
+	}
+	if err != nil {
+		return v.run(ctx, job, func(job *Job) error {
+			return err
+		})
+	}
+
+	return v.run(ctx, job, fn)
+}
+
+// Create a job of project, or returns the running job of the same kind and true. The check and the
+// append are in the same lock, so concurrent requests never start two jobs of the same kind. The
+// job of deleted project is never saved or appended, and returns error.
+func (v *JobManager) create(stage *Project, kind string, total int) (*Job, bool, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	job := NewJob(stage.SID, kind, total)
+	job.manager = v
+	if v.deleted[stage.SID] {
+		return job, false, errors.Errorf("project %v is deleted", stage.SID)
+	}
+
+	// Drop the finished jobs of the same kind, only the latest job is kept.
+	var jobs []*Job
+	for _, job := range v.jobs {
+		if job.SID == stage.SID && job.Kind == kind {
+			if job.Snapshot().State == JobRunning {
+				return job, true, nil
+			}
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	job.dir = stage.MainDir
+	v.jobs = append(jobs, job)
+	return job, false, nil
+}
+
+// RemoveFinished remove the finished jobs of project, for example, when the project is unloaded.
+func (v *JobManager) RemoveFinished(sid string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var jobs []*Job
+	for _, job := range v.jobs {
+		if job.SID != sid || job.Snapshot().State == JobRunning {
+			jobs = append(jobs, job)
+		}
+	}
+	v.jobs = jobs
+}
+
+// RemoveProject remove the jobs of project to delete it, error if any job is running. The project
+// never starts jobs after removed.
+func (v *JobManager) RemoveProject(sid string) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var jobs []*Job
+	for _, job := range v.jobs {
+		if job.SID != sid {
+			jobs = append(jobs, job)
+		} else if job.Snapshot().State == JobRunning {
+			return errors.Errorf("job %v %v is running", job.Kind, job.ID)
+		}
+	}
+
+	v.jobs, v.deleted[sid] = jobs, true
+	return nil
+}
+
+// Resume a job of project, which is loaded from the project directory.
+func (v *JobManager) Resume(ctx context.Context, stage *Project, job *Job, fn func(job *Job) error) *Job {
+	job.manager, job.dir = v, stage.MainDir
+
+	v.lock.Lock()
+	v.jobs = append(v.jobs, job)
+	v.lock.Unlock()
+
+	return v.run(ctx, job, fn)
+}
+
+// Run the fn of job in background, the job is finished when fn returns.
+func (v *JobManager) run(ctx context.Context, job *Job, fn func(job *Job) error) *Job {
+	job.update(func() {
+		job.State = JobRunning
+	})
+	job.Logf(ctx, "Start job, total=%v", job.Total)
+
+	go func() {
+		err := fn(job)
+		job.finish(err)
+		job.Logf(ctx, "Job finished, state=%v, err %v", job.Snapshot().State, err)
+	}()
+	return job
+}
+
+// Query the job by ID.
+func (v *JobManager) Query(id string) *Job {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, job := range v.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// QueryProject returns all jobs of project.
+func (v *JobManager) QueryProject(sid string) []*Job {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var jobs []*Job
+	for _, job := range v.jobs {
+		if job.SID == sid {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// Subscribe the events of project, must call Unsubscribe when done.
+func (v *JobManager) Subscribe(sid string) chan *JobEvent {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	ch := make(chan *JobEvent, 64)
+	if _, ok := v.subscribers[sid]; !ok {
+		v.subscribers[sid] = make(map[chan *JobEvent]bool)
+	}
+	v.subscribers[sid][ch] = true
+	return ch
+}
+
+func (v *JobManager) Unsubscribe(sid string, ch chan *JobEvent) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	delete(v.subscribers[sid], ch)
+	if len(v.subscribers[sid]) == 0 {
+		delete(v.subscribers, sid)
+	}
+}
+
+// Publish the event to the subscribers of project, drop the event if subscriber is slow.
+func (v *JobManager) publish(event *JobEvent) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for ch := range v.subscribers[event.SID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestJobStartConcurrent(t *testing.T) {
+	manager := NewJobManager()
+	stage := &Project{SID: uuid.NewString(), MainDir: t.TempDir()}
+
+	// Start the same kind of job concurrently, which should reuse the running one.
+	release := make(chan bool)
+	jobs := make([]*Job, 16)
+
+	var wg sync.WaitGroup
+	for i := range jobs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			jobs[i] = manager.Start(context.Background(), stage, "test", 1, func(job *Job) error {
+				<-release
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(release)
+
+	for _, job := range jobs {
+		if job != jobs[0] {
+			t.Errorf("started job %v and %v", jobs[0].ID, job.ID)
+		}
+	}
+	if n := len(manager.QueryProject(stage.SID)); n != 1 {
+		t.Errorf("started %v jobs", n)
+	}
+	if err := jobs[0].Wait(context.Background()); err != nil {
+		t.Fatalf("wait err %+v", err)
+	}
+}
+
+func TestJobSaveOnStateChange(t *testing.T) {
+	manager := NewJobManager()
+	stage := &Project{SID: uuid.NewString(), MainDir: t.TempDir()}
+
+	release := make(chan bool)
+	job := manager.Start(context.Background(), stage, "test", 2, func(job *Job) error {
+		job.Progress("a", nil)
+		<-release
+		return nil
+	})
+
+	// The progress is never saved, only the state.
+	load := func() *Job {
+		jobs, err := loadJobs(stage.MainDir)
+		if err != nil || len(jobs) != 1 {
+			t.Fatalf("load %v jobs, err %+v", len(jobs), err)
+		}
+		return jobs[0]
+	}
+	for job.Snapshot().Done == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if saved := load(); saved.State != JobRunning || saved.Done != 0 {
+		t.Errorf("saved job is %v done=%v", saved.State, saved.Done)
+	}
+
+	close(release)
+	if err := job.Wait(context.Background()); err != nil {
+		t.Fatalf("wait err %+v", err)
+	}
+	if saved := load(); saved.State != JobDone || saved.Done != 1 {
+		t.Errorf("saved job is %v done=%v", saved.State, saved.Done)
+	}
+}
+
+func TestJobRemoveFinished(t *testing.T) {
+	manager := NewJobManager()
+	stage := &Project{SID: uuid.NewString(), MainDir: t.TempDir()}
+
+	// Only the latest job of the same kind is kept.
+	for i := 0; i < 3; i++ {
+		job := manager.Start(context.Background(), stage, "test", 0, func(job *Job) error {
+			return nil
+		})
+		if err := job.Wait(context.Background()); err != nil {
+			t.Fatalf("wait err %+v", err)
+		}
+	}
+	if jobs := manager.QueryProject(stage.SID); len(jobs) != 1 {
+		t.Fatalf("keep %v jobs", len(jobs))
+	}
+
+	manager.RemoveFinished(stage.SID)
+	if jobs := manager.QueryProject(stage.SID); len(jobs) != 0 {
+		t.Fatalf("keep %v jobs", len(jobs))
+	}
+}
+
+func TestResumeJobsFailInterrupted(t *testing.T) {
+	setupTestServer(t)
+	stage := &Project{SID: uuid.NewString(), MainDir: t.TempDir()}
+
+	// The export job is running when server restart, which can't be resumed.
+	interrupted := NewJob(stage.SID, "export", 0)
+	if err := interrupted.Save(stage.MainDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resumeTTSJobs(context.Background(), stage); err != nil {
+		t.Fatalf("resume err %+v", err)
+	}
+	jobs, err := loadJobs(stage.MainDir)
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("load %v jobs, err %+v", len(jobs), err)
+	}
+	if jobs[0].State != JobFailed {
+		t.Errorf("interrupted job is %v", jobs[0].State)
+	}
+
+	// The finished job is swept.
+	if err := sweepProject(context.Background(), stage); err != nil {
+		t.Fatalf("sweep err %+v", err)
+	}
+	if jobs, err := loadJobs(stage.MainDir); err != nil || len(jobs) != 0 {
+		t.Errorf("keep %v jobs, err %+v", len(jobs), err)
+	}
+}
@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/go-audio/audio"
-	"github.com/go-audio/wav"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"github.com/ossrs/go-oryx-lib/errors"
@@ -54,6 +51,13 @@ func (v *AITime) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// AudioWord is a word of segment, with word-level timestamps.
+type AudioWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
 type AudioSegment struct {
 	ID               int     `json:"id"`
 	Seek             int     `json:"seek"`
@@ -66,6 +70,8 @@ type AudioSegment struct {
 	CompressionRatio float64 `json:"compression_ratio"`
 	NoSpeechProb     float64 `json:"no_speech_prob"`
 	Transient        bool    `json:"transient"`
+	// The words with timestamps, optional.
+	Words []AudioWord `json:"words"`
 	// The UUID generated by system.
 	UUID string `json:"uuid"`
 	// Whether user remove it.
@@ -76,12 +82,26 @@ type AudioSegment struct {
 	Translated string `json:"translated"`
 	// Translate time.
 	TranslatedAt AITime `json:"translated_at"`
+	// The engine which produced the translated text, or user for user edit.
+	TranslatedBy string `json:"translated_by"`
 	// TTS filename, without the main dir.
 	TTS string `json:"tts"`
 	// Convert TTS time.
 	TTSAt AITime `json:"tts_at"`
 	// The TTS audio duration, in seconds.
 	TTSDuration float64 `json:"tts_duration"`
+	// The TTS provider which produced the TTS file.
+	TTSProvider string `json:"tts_provider"`
+	// The TTS filename fitted to the slot, empty to use the TTS file directly.
+	Fitted string `json:"fitted"`
+	// Fit TTS time.
+	FittedAt AITime `json:"fitted_at"`
+	// The tempo to time-stretch the TTS, larger than 1 to speed up.
+	FitTempo float64 `json:"fit_tempo"`
+	// Whether the TTS is longer than the slot, even after time-stretched.
+	Overflow bool `json:"overflow"`
+	// The attempts to translate to fit the slot.
+	Attempts []TranslateAttempt `json:"attempts,omitempty"`
 }
 
 type AudioResponse struct {
@@ -90,19 +110,37 @@ type AudioResponse struct {
 	Duration float64         `json:"duration"`
 	Segments []*AudioSegment `json:"segments"`
 	Text     string          `json:"text"`
+	// The original segments before resegment, to revert.
+	Archived []*AudioSegment `json:"archived,omitempty"`
 }
 
 func NewAudioResponse() *AudioResponse {
 	return &AudioResponse{}
 }
 
-func (v *AudioResponse) AppendSegment(resp openai.AudioResponse, starttime float64) {
+func (v *AudioResponse) AppendSegment(resp *AsrResponse, starttime float64) {
 	v.Task = resp.Task
 	v.Language = resp.Language
 	v.Duration += resp.Duration
 	v.Text += " " + resp.Text
 
-	for _, s := range resp.Segments {
+	// The words are not in segments, so assign each word to the last segment starts before it.
+	words := make([][]AudioWord, len(resp.Segments))
+	for _, w := range resp.Words {
+		index := 0
+		for i, s := range resp.Segments {
+			if s.Start <= w.Start {
+				index = i
+			}
+		}
+		if index < len(words) {
+			words[index] = append(words[index], AudioWord{
+				Word: strings.TrimSpace(w.Word), Start: starttime + w.Start, End: starttime + w.End,
+			})
+		}
+	}
+
+	for i, s := range resp.Segments {
 		v.Segments = append(v.Segments, &AudioSegment{
 			// ASR Segment.
 			ID:               s.ID,
@@ -116,6 +154,7 @@ func (v *AudioResponse) AppendSegment(resp openai.AudioResponse, starttime float
 			CompressionRatio: s.CompressionRatio,
 			NoSpeechProb:     s.NoSpeechProb,
 			Transient:        s.Transient,
+			Words:            words[i],
 			// UUID.
 			UUID: uuid.NewString(),
 			// Whether user remove it.
@@ -165,10 +204,17 @@ func (v *AudioResponse) Load(filename string) error {
 	return nil
 }
 
+// Save the ASR object to file atomically, and keep the previous files as backups.
 func (v *AudioResponse) Save(filename string) error {
-	if b, err := json.Marshal(v); err != nil {
+	b, err := json.Marshal(v)
+	if err != nil {
 		return errors.Wrapf(err, "marshal")
-	} else if err = os.WriteFile(filename, b, os.FileMode(0644)); err != nil {
+	}
+
+	if err := rotateBackups(filename); err != nil {
+		return errors.Wrapf(err, "backup")
+	}
+	if err = writeFileAtomic(filename, b, os.FileMode(0644)); err != nil {
 		return errors.Wrapf(err, "write json file %v", filename)
 	}
 	return nil
@@ -181,8 +227,24 @@ type Project struct {
 	loggingCtx context.Context
 	// The input video file URL.
 	InputURL string `json:"inputURL"`
-	// Last update of stage.
+	// The title of project, set by user.
+	Title string `json:"title"`
+	// The create time.
+	Created AITime `json:"created"`
+	// The last time saved.
+	Updated AITime `json:"updated"`
+	// Last access of stage, to unload it from memory when expired.
 	update time.Time
+	// The lock to protect the update.
+	updateLock sync.Mutex
+	// The lock to protect the ASR object and project fields, for mutations and saves.
+	lock sync.Mutex
+	// Cancel the background goroutine of stage, when removed from memory.
+	cancel context.CancelFunc
+	// Whether the project is deleted, which is never saved again.
+	deleted bool
+	// The operation log of segments, loaded on demand.
+	oplog *OpLog
 	// The main directory.
 	MainDir string `json:"mainDir"`
 	// The ASR input audio file.
@@ -191,6 +253,14 @@ type Project struct {
 	asrOutputObject *AudioResponse
 	// The ASR JSON file.
 	asrOutputJSON string
+	// The translate engine of project, use VODT_TRANSLATOR if empty.
+	Translator string `json:"translator"`
+	// The ASR chunks of input audio, to resume the ASR.
+	AsrChunks []*AsrChunk `json:"asrChunks"`
+	// The style of burned subtitle, use the default style if nil.
+	SubtitleStyle *SubtitleStyle `json:"subtitleStyle"`
+	// The accompaniment filename separated from input, without the main dir.
+	Accompaniment string `json:"accompaniment"`
 }
 
 func NewProject(opts ...func(*Project)) *Project {
@@ -207,15 +277,14 @@ func NewProject(opts ...func(*Project)) *Project {
 	return v
 }
 
+// Close the project when unloaded from memory, remove the intermediate files but keep the
+// project on disk, to load it again.
 func (v *Project) Close() error {
-	if _, err := os.Stat(v.asrInputAudio); err == nil {
-		os.Remove(v.asrInputAudio)
-	}
-	if _, err := os.Stat(v.asrOutputJSON); err == nil {
-		os.Remove(v.asrOutputJSON)
-	}
-	if _, err := os.Stat(v.MainDir); err == nil {
-		os.Remove(v.MainDir)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if err := sweepProject(v.loggingCtx, v); err != nil {
+		return errors.Wrapf(err, "sweep")
 	}
 	return nil
 }
@@ -227,10 +296,19 @@ func (v *Project) buildProjectFile() string {
 func (v *Project) loadAsrObject() error {
 	v.asrOutputJSON = path.Join(v.MainDir, "input.json")
 
-	if _, err := os.Stat(v.asrOutputJSON); err == nil {
+	// Load the ASR output, or recover from backup if it's missing or broken.
+	_, err := os.Stat(v.asrOutputJSON)
+	_, backupErr := os.Stat(buildBackupFile(v.asrOutputJSON, 1))
+	if err == nil || backupErr == nil {
 		v.asrOutputObject = &AudioResponse{}
 		if err := v.asrOutputObject.Load(v.asrOutputJSON); err != nil {
-			return errors.Wrapf(err, "load json file %v", v.asrOutputJSON)
+			logger.Tf(v.loggingCtx, "Load %v failed, recover from backup, err %+v", v.asrOutputJSON, err)
+			if recoverErr := recoverFromBackup(v.loggingCtx, v.asrOutputJSON, func(filename string) error {
+				v.asrOutputObject = &AudioResponse{}
+				return v.asrOutputObject.Load(filename)
+			}); recoverErr != nil {
+				return errors.Wrapf(err, "load json file %v, recover err %v", v.asrOutputJSON, recoverErr)
+			}
 		}
 
 		// Reinitialize the segments.
@@ -259,6 +337,16 @@ func (v *Project) Load() error {
 		return errors.Wrapf(err, "unmarshal json file %v", filename)
 	}
 
+	// For legacy project without times, use the time of file.
+	if time.Time(v.Updated).IsZero() {
+		if info, err := os.Stat(filename); err == nil {
+			v.Updated = AITime(info.ModTime())
+		}
+	}
+	if time.Time(v.Created).IsZero() {
+		v.Created = v.Updated
+	}
+
 	if err := v.loadAsrObject(); err != nil {
 		return errors.Wrapf(err, "load asr object")
 	}
@@ -270,35 +358,140 @@ func (v *Project) Save() error {
 	if v.MainDir == "" {
 		return errors.Errorf("empty main dir")
 	}
+	if v.deleted {
+		return errors.Errorf("project %v is deleted", v.SID)
+	}
 	filename := v.buildProjectFile()
 
 	if err := os.MkdirAll(v.MainDir, os.ModeDir|os.FileMode(0755)); err != nil {
 		return errors.Wrapf(err, "mkdir %v", v.MainDir)
 	}
 
+	v.Updated = AITime(time.Now())
+	if time.Time(v.Created).IsZero() {
+		v.Created = v.Updated
+	}
+
 	if b, err := json.Marshal(v); err != nil {
 		return errors.Wrapf(err, "marshal")
-	} else if err = os.WriteFile(filename, b, os.FileMode(0644)); err != nil {
+	} else if err = writeFileAtomic(filename, b, os.FileMode(0644)); err != nil {
 		return errors.Wrapf(err, "write json file %v", filename)
 	}
 	return nil
 }
 
+// TranslatorName returns the translate engine of project.
+func (v *Project) TranslatorName() string {
+	if v.Translator != "" {
+		return v.Translator
+	}
+	return os.Getenv("VODT_TRANSLATOR")
+}
+
+// QuerySubtitleStyle returns the subtitle style of project, or the default style.
+func (v *Project) QuerySubtitleStyle() *SubtitleStyle {
+	if v.SubtitleStyle != nil {
+		return v.SubtitleStyle
+	}
+	return NewSubtitleStyle()
+}
+
+// AsrChunksDone whether all ASR chunks are done, true for legacy project without chunks.
+func (v *Project) AsrChunksDone() bool {
+	for _, chunk := range v.AsrChunks {
+		if chunk.State != AsrChunkDone {
+			return false
+		}
+	}
+	return true
+}
+
+// CopySegments returns the copies of segments, to read them without lock.
+func (v *Project) CopySegments() []*AudioSegment {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	var segments []*AudioSegment
+	if v.asrOutputObject != nil {
+		for _, segment := range v.asrOutputObject.Segments {
+			tmp := *segment
+			segments = append(segments, &tmp)
+		}
+	}
+	return segments
+}
+
+// QueryOpLog returns the operation log of project, load it if not loaded. Should hold the lock.
+func (v *Project) QueryOpLog() (*OpLog, error) {
+	if v.oplog == nil {
+		oplog := NewOpLog(v.MainDir)
+		if err := oplog.Load(); err != nil {
+			return nil, errors.Wrapf(err, "load oplog")
+		}
+		v.oplog = oplog
+	}
+	return v.oplog, nil
+}
+
+// Record the mutation of segments to the operation log, with copies of segments before and after.
+// Should hold the lock.
+func (v *Project) Record(kind, by string, before, after []*AudioSegment) error {
+	oplog, err := v.QueryOpLog()
+	if err != nil {
+		return errors.Wrapf(err, "oplog")
+	}
+
+	op, err := oplog.Record(kind, by, before, after)
+	if err != nil {
+		return errors.Wrapf(err, "record %v", kind)
+	}
+	logger.Tf(v.loggingCtx, "Record operation %v %v by %v, segments %v=>%v", op.ID, kind, by, len(before), len(after))
+	return nil
+}
+
+// Touch update the last access time of project.
+func (v *Project) Touch() {
+	v.updateLock.Lock()
+	defer v.updateLock.Unlock()
+
+	v.update = time.Now()
+}
+
+// LastModified returns the last time the project is edited. The segments are saved without saving
+// the project, so the time of ASR JSON is also used.
+func (v *Project) LastModified() time.Time {
+	modified := time.Time(v.Updated)
+	if info, err := os.Stat(v.asrOutputJSON); err == nil && info.ModTime().After(modified) {
+		modified = info.ModTime()
+	}
+	return modified
+}
+
+// Expired whether the project is not accessed for VODT_UNLOAD_TTL, never expire if no TTL.
 func (v *Project) Expired() bool {
-	return time.Since(v.update) > 3*24*time.Hour
+	v.updateLock.Lock()
+	defer v.updateLock.Unlock()
+
+	ttl := envDuration("VODT_UNLOAD_TTL")
+	return ttl > 0 && time.Since(v.update) > ttl
 }
 
 // The TranslatorServer is the VoD Translator server, manage stages.
 type TranslatorServer struct {
 	// All stages created by user.
 	stages []*Project
+	// The jobs of all projects.
+	jobs *JobManager
 	// The lock to protect fields.
 	lock sync.Mutex
+	// The lock to create or load stage, to avoid creating the same stage twice.
+	createLock sync.Mutex
 }
 
 func NewTranslatorServer() *TranslatorServer {
 	return &TranslatorServer{
 		stages: []*Project{},
+		jobs:   NewJobManager(),
 	}
 }
 
@@ -317,20 +510,38 @@ func (v *TranslatorServer) RemoveStage(stage *Project) {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	// Compare the pointer, because the stage may be removed and loaded again with the same SID.
 	for i, s := range v.stages {
-		if s.SID == stage.SID {
+		if s == stage {
 			v.stages = append(v.stages[:i], v.stages[i+1:]...)
+			if stage.cancel != nil {
+				stage.cancel()
+			}
 			return
 		}
 	}
 }
 
+// IsLoaded whether the stage is in memory, without touching it like QueryStage.
+func (v *TranslatorServer) IsLoaded(sid string) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, s := range v.stages {
+		if s.SID == sid {
+			return true
+		}
+	}
+	return false
+}
+
 func (v *TranslatorServer) QueryStage(sid string) *Project {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
 	for _, s := range v.stages {
 		if s.SID == sid {
+			s.Touch()
 			return s
 		}
 	}
@@ -364,21 +575,33 @@ func ParseBody(ctx context.Context, r io.ReadCloser, v interface{}) error {
 }
 
 func doCreateStage(ctx context.Context, sid string) *Project {
+	translatorServer.createLock.Lock()
+	defer translatorServer.createLock.Unlock()
+
+	// The stage may be created by other request, while waiting for the lock.
+	if stage := translatorServer.QueryStage(sid); stage != nil {
+		return stage
+	}
+
 	ctx = logger.WithContext(ctx)
+	stageCtx, stageCancel := context.WithCancel(ctx)
 	project := NewProject(func(project *Project) {
 		project.loggingCtx = ctx
 		project.SID = sid
-		project.MainDir = path.Join(workDir, "projects", fmt.Sprintf("project-%v", sid))
+		project.MainDir = buildProjectDir(sid)
+		project.cancel = stageCancel
 	})
 
 	// If stage exists, load it.
 	filename := project.buildProjectFile()
 	if _, err := os.Stat(filename); err == nil {
 		if err := project.Load(); err != nil {
+			stageCancel()
 			return nil
 		}
 	} else {
 		if err := project.Save(); err != nil {
+			stageCancel()
 			return nil
 		}
 	}
@@ -386,17 +609,23 @@ func doCreateStage(ctx context.Context, sid string) *Project {
 	translatorServer.AddStage(project)
 	logger.Tf(ctx, "Create project sid=%v", project.SID)
 
-	go func() {
-		defer project.Close()
+	if err := resumeTTSJobs(ctx, project); err != nil {
+		logger.Tf(ctx, "Resume TTS jobs failed, err %+v", err)
+	}
 
-		for ctx.Err() == nil {
+	// Unload the stage when expired, or quit when removed, for example, deleted by user.
+	go func() {
+		for {
 			select {
-			case <-ctx.Done():
+			case <-stageCtx.Done():
+				// Removed by other, which should sweep the project if required.
+				return
 			case <-time.After(3 * time.Second):
-				if project.Expired() {
-					logger.Tf(ctx, "Project: Remove %v for expired, update=%v",
-						project.SID, project.update.Format(time.RFC3339))
+				if project.Expired() && !hasRunningJob(project.SID) {
+					logger.Tf(ctx, "Project: Unload %v for expired", project.SID)
 					translatorServer.RemoveStage(project)
+					translatorServer.jobs.RemoveFinished(project.SID)
+					project.Close()
 					return
 				}
 			}
@@ -417,6 +646,81 @@ func handleStageCreate(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	return nil
 }
 
+func handleProjectList(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	projects, err := listProjects(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "list projects")
+	}
+	logger.Tf(ctx, "List projects ok, projects=%v", len(projects))
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Projects []*ProjectSummary `json:"projects"`
+	}{
+		Projects: projects,
+	})
+	return nil
+}
+
+func handleProjectDelete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+	}{
+		SID: &sid,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	translatorServer.createLock.Lock()
+	err := deleteProject(ctx, sid)
+	translatorServer.createLock.Unlock()
+	if err != nil {
+		return errors.Wrapf(err, "delete project %v", sid)
+	}
+	logger.Tf(ctx, "Delete project sid=%v ok", sid)
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		SID string `json:"sid"`
+	}{
+		SID: sid,
+	})
+	return nil
+}
+
+func handleProjectRename(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid, title string
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID   *string `json:"sid"`
+		Title *string `json:"title"`
+	}{
+		SID: &sid, Title: &title,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	project, err := queryOrLoadStage(ctx, sid)
+	if err != nil {
+		return errors.Wrapf(err, "query project")
+	}
+	ctx = project.loggingCtx
+
+	project.lock.Lock()
+	defer project.lock.Unlock()
+
+	project.Title = strings.TrimSpace(title)
+	if err := project.Save(); err != nil {
+		return errors.Wrapf(err, "save project")
+	}
+	logger.Tf(ctx, "Rename project sid=%v, title=%v", project.SID, project.Title)
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Project *ProjectSummary `json:"project"`
+	}{
+		Project: buildProjectSummary(project),
+	})
+	return nil
+}
+
 func handleStageLoad(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var sid string
 	if err := ParseBody(ctx, r.Body, &struct {
@@ -434,6 +738,9 @@ func handleStageLoad(ctx context.Context, w http.ResponseWriter, r *http.Request
 
 	ctx = project.loggingCtx
 
+	project.lock.Lock()
+	defer project.lock.Unlock()
+
 	ohttp.WriteData(ctx, w, r, &struct {
 		// The UUID of stage.
 		SID string `json:"sid"`
@@ -445,13 +752,71 @@ func handleStageLoad(ctx context.Context, w http.ResponseWriter, r *http.Request
 	return nil
 }
 
+func handleStageSettings(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	var translator *string
+	var style *SubtitleStyle
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID           *string         `json:"sid"`
+		Translator    **string        `json:"translator"`
+		SubtitleStyle **SubtitleStyle `json:"subtitle_style"`
+	}{
+		SID: &sid, Translator: &translator, SubtitleStyle: &style,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	project := translatorServer.QueryStage(sid)
+	if project == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = project.loggingCtx
+
+	project.lock.Lock()
+	defer project.lock.Unlock()
+
+	if translator != nil {
+		if *translator != "" {
+			if _, err := NewTranslator(*translator); err != nil {
+				return errors.Wrapf(err, "translator %v", *translator)
+			}
+		}
+		project.Translator = *translator
+	}
+
+	if style != nil {
+		if err := style.Validate(); err != nil {
+			return errors.Wrapf(err, "subtitle style")
+		}
+		project.SubtitleStyle = style
+	}
+
+	if err := project.Save(); err != nil {
+		return errors.Wrapf(err, "save project")
+	}
+	logger.Tf(ctx, "Update project sid=%v, translator=%v, style=%v",
+		project.SID, project.TranslatorName(), project.SubtitleStyle != nil)
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		SID           string         `json:"sid"`
+		Translator    string         `json:"translator"`
+		SubtitleStyle *SubtitleStyle `json:"subtitle_style"`
+	}{
+		SID: project.SID, Translator: project.TranslatorName(), SubtitleStyle: project.QuerySubtitleStyle(),
+	})
+	return nil
+}
+
 func handleStageAsr(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var sid, inputURL string
+	var async bool
 	if err := ParseBody(ctx, r.Body, &struct {
 		SID      *string `json:"sid"`
 		InputURL *string `json:"url"`
+		// Whether response the job immediately, without waiting for the ASR.
+		Async *bool `json:"async"`
 	}{
-		SID: &sid, InputURL: &inputURL,
+		SID: &sid, InputURL: &inputURL, Async: &async,
 	}); err != nil {
 		return errors.Wrapf(err, "parse body")
 	}
@@ -462,102 +827,116 @@ func handleStageAsr(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	}
 
 	ctx = project.loggingCtx
+	project.lock.Lock()
 	project.asrInputAudio = path.Join(project.MainDir, "input.m4a")
+	project.asrOutputJSON = path.Join(project.MainDir, "input.json")
 	logger.Tf(ctx, "Handle project sid=%v, main=%v, url=%v, output=%v",
 		project.SID, project.MainDir, inputURL, project.asrInputAudio)
 
-	// Convert input to audio only file.
-	if _, err := os.Stat(project.asrInputAudio); err != nil {
-		project.InputURL = inputURL
-		if err := project.Save(); err != nil {
-			return errors.Wrapf(err, "save project")
-		}
+	// Load ASR from JSON file, if all chunks are done.
+	_, err := os.Stat(project.asrOutputJSON)
+	asrDone := err == nil && project.AsrChunksDone()
+	if asrDone {
+		err = project.loadAsrObject()
+	}
+	project.lock.Unlock()
 
-		inputFile := inputURL
-		if strings.HasPrefix(inputFile, "/api/vod-translator/resources/") {
-			inputFile = path.Join("static", inputFile[len("/api/vod-translator/resources/"):])
+	if asrDone {
+		if err != nil {
+			return errors.Wrapf(err, "load asr object")
 		}
-		if _, err := os.Stat(inputFile); err != nil {
-			return errors.Wrapf(err, "no file %v", inputFile)
+		logger.Tf(ctx, "Load ASR object from %v ok", project.asrOutputJSON)
+	} else {
+		job := translatorServer.jobs.Start(ctx, project, "asr", 0, func(job *Job) error {
+			return doAsrStage(ctx, project, inputURL, job)
+		})
+
+		if async {
+			ohttp.WriteData(ctx, w, r, &struct {
+				SID string `json:"sid"`
+				Job *Job   `json:"job"`
+			}{
+				SID: project.SID, Job: job.Snapshot(),
+			})
+			return nil
 		}
 
-		if true {
-			if err := exec.CommandContext(ctx, "ffmpeg",
-				"-i", inputFile,
-				"-vn", "-c:a", "aac", "-ac", "1", "-ar", "16000", "-ab", "50k",
-				project.asrInputAudio,
-			).Run(); err != nil {
-				return errors.Errorf("Error converting the file")
-			}
-			logger.Tf(ctx, "Convert to ogg %v ok", project.asrInputAudio)
+		if err := job.Wait(r.Context()); err != nil {
+			return errors.Wrapf(err, "asr")
 		}
 	}
 
-	// Load ASR from JSON file.
-	project.asrOutputJSON = path.Join(project.MainDir, "input.json")
-	if _, err := os.Stat(project.asrOutputJSON); err == nil {
-		if err := project.loadAsrObject(); err != nil {
-			return errors.Wrapf(err, "load asr object")
-		}
-		logger.Tf(ctx, "Load ASR object from %v ok", project.asrOutputJSON)
-	} else {
-		// Load the duration of input file.
-		duration, bitrate, err := detectInput(ctx, project)
-		if err != nil {
-			return errors.Wrapf(err, "detect input")
-		}
-
-		// Reset the ASR output object.
-		project.asrOutputObject = NewAudioResponse()
-
-		// Split the audio to segments, because each ASR is limited to 25MB by OpenAI,
-		// see https://platform.openai.com/docs/guides/speech-to-text
-		limitDuration := int(25*1024*1024*8/float64(bitrate)) / 10
-		for starttime := float64(0); starttime < duration; starttime += float64(limitDuration) {
-			if err := func() error {
-				tmpAsrInputAudio := path.Join(project.MainDir, fmt.Sprintf("input-%v.m4a", starttime))
-				defer os.Remove(tmpAsrInputAudio)
-
-				if err := exec.CommandContext(ctx, "ffmpeg",
-					"-i", project.asrInputAudio,
-					"-ss", fmt.Sprintf("%v", starttime), "-t", fmt.Sprintf("%v", limitDuration),
-					"-c", "copy", "-y", tmpAsrInputAudio,
-				).Run(); err != nil {
-					return errors.Errorf("Error converting the file %v", tmpAsrInputAudio)
-				}
-				logger.Tf(ctx, "Convert to segment %v ok, starttime=%v", tmpAsrInputAudio, starttime)
-
-				// Do ASR, convert to text.
-				client := openai.NewClientWithConfig(aiConfig)
-				resp, err := client.CreateTranscription(
-					ctx,
-					openai.AudioRequest{
-						Model:    openai.Whisper1,
-						FilePath: tmpAsrInputAudio,
-						Format:   openai.AudioResponseFormatVerboseJSON,
-						Language: os.Getenv("VODT_ASR_LANGUAGE"),
-					},
-				)
-				if err != nil {
-					return errors.Wrapf(err, "transcription")
-				}
-				logger.Tf(ctx, "ASR ok, project=%v, resp is <%v>B, segments=%v",
-					project.SID, len(resp.Text), len(project.asrOutputObject.Segments))
+	project.lock.Lock()
+	defer project.lock.Unlock()
 
-				// Append the segment to ASR output object.
-				project.asrOutputObject.AppendSegment(resp, starttime)
-				if err := project.asrOutputObject.Save(project.asrOutputJSON); err != nil {
-					return errors.Wrapf(err, "save")
-				}
-				logger.Tf(ctx, "Save ASR output to %v ok", project.asrOutputJSON)
+	ohttp.WriteData(ctx, w, r, &struct {
+		SID string         `json:"sid"`
+		ASR *AudioResponse `json:"asr"`
+	}{
+		SID: project.SID, ASR: project.asrOutputObject,
+	})
+	return nil
+}
 
-				return nil
-			}(); err != nil {
-				return errors.Wrapf(err, "split starttime=%v, duration=%v", starttime, limitDuration)
-			}
+func handleStageImport(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid, inputURL, content string
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+		// The input video, optional, for export.
+		InputURL *string `json:"url"`
+		// The content of SRT or VTT subtitle.
+		Content *string `json:"content"`
+	}{
+		SID: &sid, InputURL: &inputURL, Content: &content,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	asr, err := ParseSubtitle(content)
+	if err != nil {
+		return errors.Wrapf(err, "parse subtitle")
+	}
+
+	project := translatorServer.QueryStage(sid)
+	if project == nil {
+		project = doCreateStage(ctx, sid)
+	}
+	ctx = project.loggingCtx
+
+	project.lock.Lock()
+	defer project.lock.Unlock()
+
+	for _, job := range translatorServer.jobs.QueryProject(project.SID) {
+		if job.Kind == "asr" && job.Snapshot().State == JobRunning {
+			return errors.Errorf("asr job %v is running", job.ID)
 		}
 	}
 
+	var before []*AudioSegment
+	if project.asrOutputObject != nil {
+		before = copySegments(project.asrOutputObject.Segments...)
+	}
+
+	// The imported subtitle is the ASR output, so the ASR stage is skipped.
+	project.asrOutputJSON = path.Join(project.MainDir, "input.json")
+	project.asrOutputObject = asr
+	if err := project.asrOutputObject.Save(project.asrOutputJSON); err != nil {
+		return errors.Wrapf(err, "save")
+	}
+
+	project.AsrChunks = nil
+	if inputURL != "" {
+		project.InputURL = inputURL
+	}
+	if err := project.Save(); err != nil {
+		return errors.Wrapf(err, "save project")
+	}
+	if err := project.Record(OperationImport, "user", before, copySegments(asr.Segments...)); err != nil {
+		return errors.Wrapf(err, "record")
+	}
+	logger.Tf(ctx, "Import subtitle ok, segments=%v, duration=%v, url=%v",
+		len(asr.Segments), asr.Duration, project.InputURL)
+
 	ohttp.WriteData(ctx, w, r, &struct {
 		SID string         `json:"sid"`
 		ASR *AudioResponse `json:"asr"`
@@ -585,14 +964,19 @@ func handleStageAsrUpdate(ctx context.Context, w http.ResponseWriter, r *http.Re
 	}
 	ctx = stage.loggingCtx
 
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
 	target := stage.asrOutputObject.QuerySegment(segment.UUID)
 	if target == nil {
 		return errors.Errorf("no segment %v", segment.UUID)
 	}
 
 	// Update target.
+	before := copySegments(target)
 	if target.Translated != segment.Translated {
 		target.TranslatedAt = AITime(time.Now())
+		target.TranslatedBy = "user"
 	}
 	target.Removed = segment.Removed
 	target.Update = AITime(time.Now())
@@ -604,6 +988,10 @@ func handleStageAsrUpdate(ctx context.Context, w http.ResponseWriter, r *http.Re
 	}
 	logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
 
+	if err := stage.Record(OperationUpdate, "user", before, copySegments(target)); err != nil {
+		return errors.Wrapf(err, "record")
+	}
+
 	ohttp.WriteData(ctx, w, r, nil)
 	return nil
 }
@@ -626,51 +1014,47 @@ func handleStageTranslate(ctx context.Context, w http.ResponseWriter, r *http.Re
 	}
 	ctx = stage.loggingCtx
 
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
 	target := stage.asrOutputObject.QuerySegment(segment.UUID)
 	if target == nil {
 		return errors.Errorf("no segment %v", segment.UUID)
 	}
 
-	shouldTranslate := func(target *AudioSegment) bool {
-		if target.Removed || target.Text == "" {
-			return false
-		}
-		return target.Translated == "" || time.Time(target.Update).After(time.Time(target.TranslatedAt))
-	}
 	if shouldTranslate(target) {
-		messages := []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleSystem, Content: os.Getenv("VODT_CHAT_PROMPT")},
-		}
-		previous := stage.asrOutputObject.QueryPrevious(target)
-		if previous != nil && previous.Translated != "" && previous.Text != "" {
-			messages = append(messages, openai.ChatCompletionMessage{
-				Role: openai.ChatMessageRoleUser, Content: previous.Text,
-			})
-			messages = append(messages, openai.ChatCompletionMessage{
-				Role: openai.ChatMessageRoleAssistant, Content: previous.Translated,
-			})
+		engine := stage.TranslatorName()
+		translator, err := NewTranslator(engine)
+		if err != nil {
+			return errors.Wrapf(err, "translator %v", engine)
 		}
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role: openai.ChatMessageRoleUser, Content: target.Text,
-		})
 
-		client := openai.NewClientWithConfig(aiConfig)
-		resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-			Model:    os.Getenv("VODT_CHAT_MODEL"),
-			Messages: messages,
-		})
+		// Translate without lock, because it's slow.
+		req := buildTranslateRequest(stage, target)
+		stage.lock.Unlock()
+		translated, err := translator.Translate(ctx, req)
+		stage.lock.Lock()
 		if err != nil {
 			return errors.Wrapf(err, "translate")
 		}
+		if target = stage.asrOutputObject.QuerySegment(segment.UUID); target == nil {
+			return errors.Errorf("segment %v is removed while translating", segment.UUID)
+		}
 
-		target.Translated = resp.Choices[0].Message.Content
+		before := copySegments(target)
+		target.Translated = translated
 		target.TranslatedAt = AITime(time.Now())
-		logger.Tf(ctx, "Translate ok, messages=%v, resp is <%v>B", len(messages), len(target.Translated))
+		target.TranslatedBy = engine
+		logger.Tf(ctx, "Translate ok, engine=%v, resp is <%v>B", engine, len(target.Translated))
 
 		if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
 			return errors.Wrapf(err, "save")
 		}
 		logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
+
+		if err := stage.Record(OperationTranslate, engine, before, copySegments(target)); err != nil {
+			return errors.Wrapf(err, "record")
+		}
 	} else {
 		logger.Tf(ctx, "Ignore translation for %v", target)
 	}
@@ -683,17 +1067,22 @@ func handleStageTranslate(ctx context.Context, w http.ResponseWriter, r *http.Re
 	return nil
 }
 
-func handleStageShorter(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+func handleStageTranslateFit(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var sid string
 	var segment AudioSegment
+	opts := FitTranslateOptions{Attempts: 3}
 	if err := ParseBody(ctx, r.Body, &struct {
 		SID     *string       `json:"sid"`
 		Segment *AudioSegment `json:"segment"`
+		*FitTranslateOptions
 	}{
-		SID: &sid, Segment: &segment,
+		SID: &sid, Segment: &segment, FitTranslateOptions: &opts,
 	}); err != nil {
 		return errors.Wrapf(err, "parse body")
 	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 1
+	}
 
 	stage := translatorServer.QueryStage(sid)
 	if stage == nil {
@@ -701,129 +1090,235 @@ func handleStageShorter(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	}
 	ctx = stage.loggingCtx
 
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
 	target := stage.asrOutputObject.QuerySegment(segment.UUID)
 	if target == nil {
 		return errors.Errorf("no segment %v", segment.UUID)
 	}
 
-	if true {
-		messages := []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleSystem, Content: os.Getenv("VODT_SHORTER_PROMPT")},
-		}
-		if previous := stage.asrOutputObject.QueryPrevious(target); previous != nil && previous.Translated != "" {
-			messages = append(messages, []openai.ChatCompletionMessage{
-				{Role: openai.ChatMessageRoleUser, Content: previous.Translated},
-				{Role: openai.ChatMessageRoleAssistant, Content: previous.Translated},
-			}...)
-		}
-		messages = append(messages, []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: target.Translated},
-		}...)
-
-		client := openai.NewClientWithConfig(aiConfig)
-		resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-			Model:    os.Getenv("VODT_SHORTER_MODEL"),
-			Messages: messages,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "translate")
+	// Translate a copy of segment without lock, because it's slow.
+	tmp, req, engine := *target, buildTranslateRequest(stage, target), stage.TranslatorName()
+	stage.lock.Unlock()
+	err := doFitTranslate(ctx, stage, engine, &tmp, req, &opts)
+	stage.lock.Lock()
+	if err != nil {
+		return errors.Wrapf(err, "fit translate")
+	}
+	if target = stage.asrOutputObject.QuerySegment(segment.UUID); target == nil {
+		if opts.Measure {
+			os.Remove(path.Join(stage.MainDir, tmp.TTS))
 		}
+		return errors.Errorf("segment %v is removed while translating", segment.UUID)
+	}
 
-		target.Translated = resp.Choices[0].Message.Content
-		target.TranslatedAt = AITime(time.Now())
-		logger.Tf(ctx, "Translate ok, messages=%v, resp is <%v>B", len(messages), len(target.Translated))
-
-		if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
-			return errors.Wrapf(err, "save")
+	before := copySegments(target)
+	target.Translated, target.TranslatedAt, target.TranslatedBy = tmp.Translated, tmp.TranslatedAt, tmp.TranslatedBy
+	target.Attempts = tmp.Attempts
+	if opts.Measure {
+		if err := acceptAttemptTTS(stage, &tmp); err != nil {
+			return errors.Wrapf(err, "accept tts")
 		}
-		logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
-	} else {
-		logger.Tf(ctx, "Ignore translation for %v", target)
+		target.TTS, target.TTSAt, target.TTSProvider = tmp.TTS, tmp.TTSAt, tmp.TTSProvider
+		target.TTSDuration = tmp.TTSDuration
 	}
 
-	ohttp.WriteData(ctx, w, r, &struct {
-		Segment *AudioSegment `json:"segment"`
-	}{
+	if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
+		return errors.Wrapf(err, "save")
+	}
+	logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
+
+	if err := stage.Record(OperationTranslateFit, target.TranslatedBy, before, copySegments(target)); err != nil {
+		return errors.Wrapf(err, "record")
+	}
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Segment *AudioSegment `json:"segment"`
+	}{
 		Segment: target,
 	})
 	return nil
 }
 
-func doTTS(ctx context.Context, stage *Project, target *AudioSegment) error {
-	if os.Getenv("VODT_TTS_PROVIDER") == "openai" {
-		client := openai.NewClientWithConfig(aiConfig)
-		resp, err := client.CreateSpeech(ctx, openai.CreateSpeechRequest{
-			Model:          openai.TTSModel1,
-			Input:          target.Translated,
-			Voice:          openai.VoiceNova,
-			ResponseFormat: openai.SpeechResponseFormatAac,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "create speech")
-		}
-		defer resp.Close()
+func handleStageTranslateAll(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	opts := TranslateAllOptions{Concurrency: 3, Retry: 3}
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+		*TranslateAllOptions
+	}{
+		SID: &sid, TranslateAllOptions: &opts,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Retry < 0 {
+		opts.Retry = 0
+	}
 
-		ttsFilename := fmt.Sprintf("tts-%v.aac", target.UUID)
-		ttsFile := path.Join(stage.MainDir, ttsFilename)
-		out, err := os.Create(ttsFile)
-		if err != nil {
-			return errors.Errorf("Unable to create the file %v for writing", ttsFile)
-		}
-		defer out.Close()
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
 
-		if _, err = io.Copy(out, resp); err != nil {
-			return errors.Errorf("Error writing the file")
-		}
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
 
-		target.TTS = ttsFilename
-		target.TTSAt = AITime(time.Now())
-		logger.Tf(ctx, "TTS ok")
-		return nil
-	} else if os.Getenv("VODT_TTS_PROVIDER") == "11labs" {
-		url := fmt.Sprintf("https://api.elevenlabs.io/v1/text-to-speech/%v", os.Getenv("VODT_11LABS_VOICE"))
+	if stage.asrOutputObject == nil {
+		return errors.Errorf("no asr object of %v", sid)
+	}
 
-		data := map[string]string{
-			"text": target.Translated,
-		}
-		b, err := json.Marshal(data)
-		if err != nil {
-			return errors.Errorf("Unable to marshal the data")
+	var segments []*AudioSegment
+	for _, segment := range stage.asrOutputObject.Segments {
+		if shouldTranslate(segment) {
+			segments = append(segments, segment)
 		}
+	}
 
-		req, err := http.NewRequest("POST", url, bytes.NewReader(b))
-		if err != nil {
-			return errors.Errorf("Unable to create the request")
-		}
+	job := translatorServer.jobs.Start(ctx, stage, "translate", len(segments), func(job *Job) error {
+		return doTranslateAll(ctx, stage, job, segments, &opts)
+	})
+	logger.Tf(ctx, "Translate all segments, job=%v, segments=%v, opts=%+v", job.ID, len(segments), opts)
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Job *Job `json:"job"`
+	}{
+		Job: job.Snapshot(),
+	})
+	return nil
+}
+
+func handleStageShorter(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	var segment AudioSegment
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID     *string       `json:"sid"`
+		Segment *AudioSegment `json:"segment"`
+	}{
+		SID: &sid, Segment: &segment,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		stage = doCreateStage(ctx, sid)
+	}
+	ctx = stage.loggingCtx
 
-		req.Header.Set("Accept", "audio/mpeg")
-		req.Header.Add("xi-api-key", os.Getenv("VODT_11LABS_KEY"))
-		req.Header.Add("Content-Type", "application/json")
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
 
-		res, err := http.DefaultClient.Do(req)
+	target := stage.asrOutputObject.QuerySegment(segment.UUID)
+	if target == nil {
+		return errors.Errorf("no segment %v", segment.UUID)
+	}
+
+	if true {
+		budget := speakingBudget(target)
+		estimated := estimateSpeakingDuration(target.Translated)
+		limit := shorterLimit(target.Translated, estimated, budget)
+
+		// Shorten without lock, because it's slow.
+		previous, text := buildTranslateRequest(stage, target).PreviousTranslated, target.Translated
+		stage.lock.Unlock()
+		translated, err := doShorter(ctx, previous, text, limit)
+		stage.lock.Lock()
 		if err != nil {
-			return errors.Errorf("Unable to send the request")
+			return errors.Wrapf(err, "translate")
+		}
+		if target = stage.asrOutputObject.QuerySegment(segment.UUID); target == nil {
+			return errors.Errorf("segment %v is removed while shortening", segment.UUID)
 		}
-		defer res.Body.Close()
 
-		ttsFilename := fmt.Sprintf("tts-%v.mp3", target.UUID)
-		ttsFile := path.Join(stage.MainDir, ttsFilename)
-		out, err := os.Create(ttsFile)
-		if err != nil {
-			return errors.Errorf("Unable to create the file %v for writing", ttsFile)
+		before := copySegments(target)
+		target.Translated = translated
+		target.TranslatedAt = AITime(time.Now())
+		target.TranslatedBy = "shorter"
+		attempt := TranslateAttempt{
+			Translated: translated, Estimated: estimateSpeakingDuration(translated), Budget: budget,
+			By: target.TranslatedBy, Created: target.TranslatedAt,
 		}
-		defer out.Close()
+		attempt.Fit = attempt.Estimated <= budget
+		target.Attempts = append(target.Attempts, attempt)
+		logger.Tf(ctx, "Translate ok, limit=%v, budget=%.3f, estimated=%.3f, resp is <%v>B",
+			limit, budget, attempt.Estimated, len(target.Translated))
 
-		if _, err = io.Copy(out, res.Body); err != nil {
-			return errors.Errorf("Error writing the file")
+		if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
+			return errors.Wrapf(err, "save")
 		}
+		logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
 
-		target.TTS = ttsFilename
-		target.TTSAt = AITime(time.Now())
-		logger.Tf(ctx, "TTS ok")
-		return nil
+		if err := stage.Record(OperationShorter, target.TranslatedBy, before, copySegments(target)); err != nil {
+			return errors.Wrapf(err, "record")
+		}
 	} else {
-		return errors.Errorf("Unknown TTS provider %v", os.Getenv("VODT_TTS_PROVIDER"))
+		logger.Tf(ctx, "Ignore translation for %v", target)
+	}
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Segment *AudioSegment `json:"segment"`
+	}{
+		Segment: target,
+	})
+	return nil
+}
+
+func handleJobQuery(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var id string
+	if err := ParseBody(ctx, r.Body, &struct {
+		ID *string `json:"id"`
+	}{
+		ID: &id,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	job := translatorServer.jobs.Query(id)
+	if job == nil {
+		return errors.Errorf("no job %v", id)
+	}
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Job *Job `json:"job"`
+	}{
+		Job: job.Snapshot(),
+	})
+	return nil
+}
+
+func doTTS(ctx context.Context, stage *Project, target *AudioSegment) error {
+	ttsFilename, name, err := synthesizeTTS(ctx, stage, target.Translated, fmt.Sprintf("tts-%v", target.UUID))
+	if err != nil {
+		return err
+	}
+
+	target.TTS = ttsFilename
+	target.TTSAt = AITime(time.Now())
+	target.TTSProvider = name
+	logger.Tf(ctx, "TTS ok, provider=%v, file=%v", name, ttsFilename)
+	return nil
+}
+
+// Synthesize the text to file <prefix>.<container> in project by VODT_TTS_PROVIDER, returns the
+// filename and the provider.
+func synthesizeTTS(ctx context.Context, stage *Project, text, prefix string) (string, string, error) {
+	name := os.Getenv("VODT_TTS_PROVIDER")
+	provider := QueryTTSProvider(name)
+	if provider == nil {
+		return "", "", errors.Errorf("Unknown TTS provider %v", name)
 	}
+
+	ttsFilename := fmt.Sprintf("%v.%v", prefix, provider.Container())
+	ttsFile := path.Join(stage.MainDir, ttsFilename)
+	if err := provider.Synthesize(ctx, text, ttsFile); err != nil {
+		return "", "", errors.Wrapf(err, "synthesize by %v", name)
+	}
+	return ttsFilename, name, nil
 }
 
 func detectInput(ctx context.Context, stage *Project) (duration float64, bitrate int, err error) {
@@ -933,29 +1428,42 @@ func handleStageTTS(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	}
 	ctx = stage.loggingCtx
 
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
 	target := stage.asrOutputObject.QuerySegment(segment.UUID)
 	if target == nil {
 		return errors.Errorf("no segment %v", segment.UUID)
 	}
 
-	shouldTTS := func(target *AudioSegment) bool {
-		if target.Removed || target.Text == "" || target.Translated == "" {
-			return false
+	// Convert a copy of segment without lock, because it's slow.
+	tmp := *target
+	stage.lock.Unlock()
+	err := func() error {
+		if shouldTTS(&tmp) {
+			if err := doTTS(ctx, stage, &tmp); err != nil {
+				return errors.Wrapf(err, "tts")
+			}
+		} else {
+			logger.Tf(ctx, "Ignore TTS for %v", tmp.UUID)
 		}
-		return target.TTS == "" || target.TTSDuration <= 0 || time.Time(target.TranslatedAt).After(time.Time(target.TTSAt))
-	}
-	if shouldTTS(target) {
-		if err := doTTS(ctx, stage, target); err != nil {
-			return errors.Wrapf(err, "tts")
+
+		if err := detectTTS(ctx, stage, &tmp); err != nil {
+			return errors.Wrapf(err, "detect")
 		}
-	} else {
-		logger.Tf(ctx, "Ignore TTS for %v", target)
+		return nil
+	}()
+	stage.lock.Lock()
+	if err != nil {
+		return err
 	}
-
-	if err := detectTTS(ctx, stage, target); err != nil {
-		return errors.Wrapf(err, "detect")
+	if target = stage.asrOutputObject.QuerySegment(segment.UUID); target == nil {
+		return errors.Errorf("segment %v is removed while converting", segment.UUID)
 	}
 
+	target.TTS, target.TTSAt, target.TTSProvider = tmp.TTS, tmp.TTSAt, tmp.TTSProvider
+	target.TTSDuration = tmp.TTSDuration
+
 	if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
 		return errors.Wrapf(err, "save")
 	}
@@ -969,6 +1477,125 @@ func handleStageTTS(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	return nil
 }
 
+func handleStageTTSAll(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+	}{
+		SID: &sid,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	if stage.asrOutputObject == nil {
+		return errors.Errorf("no asr object of %v", sid)
+	}
+
+	var segments []*AudioSegment
+	for _, segment := range stage.asrOutputObject.Segments {
+		if shouldTTS(segment) {
+			segments = append(segments, segment)
+		}
+	}
+
+	job := translatorServer.jobs.Start(ctx, stage, "tts", len(segments), func(job *Job) error {
+		return doTTSAll(ctx, stage, job, segments)
+	})
+	logger.Tf(ctx, "TTS all segments, job=%v, segments=%v", job.ID, len(segments))
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Job *Job `json:"job"`
+	}{
+		Job: job.Snapshot(),
+	})
+	return nil
+}
+
+func handleStageSeparate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+	}{
+		SID: &sid,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	if stage.InputURL == "" {
+		return errors.Errorf("no input of %v", sid)
+	}
+
+	job := translatorServer.jobs.Start(ctx, stage, "separate", 0, func(job *Job) error {
+		return doSeparate(ctx, stage, job)
+	})
+	logger.Tf(ctx, "Separate vocals, job=%v, input=%v", job.ID, stage.InputURL)
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Job *Job `json:"job"`
+	}{
+		Job: job.Snapshot(),
+	})
+	return nil
+}
+
+func handleStageFit(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+	}{
+		SID: &sid,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	if stage.asrOutputObject == nil {
+		return errors.Errorf("no asr object of %v", sid)
+	}
+
+	var segments []*AudioSegment
+	for _, segment := range stage.asrOutputObject.Segments {
+		if shouldFit(segment) {
+			segments = append(segments, segment)
+		}
+	}
+
+	job := translatorServer.jobs.Start(ctx, stage, "fit", len(segments), func(job *Job) error {
+		return doFitAll(ctx, stage, job, segments)
+	})
+	logger.Tf(ctx, "Fit all segments, job=%v, segments=%v", job.ID, len(segments))
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		Job *Job `json:"job"`
+	}{
+		Job: job.Snapshot(),
+	})
+	return nil
+}
+
 func handleStagePreview(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	ss := strings.Split(r.URL.Path[len("/api/vod-translator/preview/"):], "/")
 	sid, uuid, filename := ss[0], ss[1], ss[2]
@@ -979,16 +1606,22 @@ func handleStagePreview(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	}
 	ctx = stage.loggingCtx
 
+	stage.lock.Lock()
 	target := stage.asrOutputObject.QuerySegment(uuid)
 	if target == nil {
+		stage.lock.Unlock()
 		return errors.Errorf("no segment %v", uuid)
 	}
-	logger.Tf(ctx, "Serve TTS %v %v", target, filename)
+	tts, provider := target.TTS, target.TTSProvider
+	stage.lock.Unlock()
+	logger.Tf(ctx, "Serve TTS %v %v", tts, filename)
 
-	w.Header().Set("Content-Type", "audio/aac")
+	if contentType := QueryTTSContentType(provider, tts); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
 
 	ttsFileServer := http.FileServer(http.Dir(path.Join(stage.MainDir)))
-	r.URL.Path = fmt.Sprintf("/%v", target.TTS)
+	r.URL.Path = fmt.Sprintf("/%v", tts)
 	ttsFileServer.ServeHTTP(w, r)
 	return nil
 }
@@ -1012,6 +1645,9 @@ func handleStageMerge(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	}
 	ctx = stage.loggingCtx
 
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
 	target := stage.asrOutputObject.QuerySegment(segment.UUID)
 	if target == nil {
 		return errors.Errorf("no segment %v", segment.UUID)
@@ -1030,20 +1666,40 @@ func handleStageMerge(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return errors.Errorf("invalid %v next %v", segment, nextSegment)
 	}
 
-	target.End = next.End
-	target.Text += " " + next.Text
-	target.Tokens = append(target.Tokens, next.Tokens...)
-	target.Translated += " " + next.Translated
-	target.TranslatedAt = AITime(time.Now())
+	// Merge to a copy of target, then convert it without lock, because it's slow.
+	before := copySegments(target, next)
+	merged := *target
+	merged.End = next.End
+	merged.Text += " " + next.Text
+	merged.Tokens = append(append([]int{}, target.Tokens...), next.Tokens...)
+	merged.Words = append(append([]AudioWord{}, target.Words...), next.Words...)
+	merged.Translated += " " + next.Translated
+	merged.TranslatedAt = AITime(time.Now())
+	if merged.TranslatedBy != next.TranslatedBy && next.TranslatedBy != "" {
+		merged.TranslatedBy += "+" + next.TranslatedBy
+	}
 
-	if err := doTTS(ctx, stage, target); err != nil {
+	stage.lock.Unlock()
+	err := doTTS(ctx, stage, &merged)
+	if err == nil {
+		err = detectTTS(ctx, stage, &merged)
+	}
+	stage.lock.Lock()
+	if err != nil {
 		return errors.Wrapf(err, "tts")
 	}
-	if err := detectTTS(ctx, stage, target); err != nil {
-		return errors.Wrapf(err, "detect")
+
+	// The segments may be changed while converting, so query and check them again.
+	target = stage.asrOutputObject.QuerySegment(segment.UUID)
+	next = stage.asrOutputObject.QuerySegment(nextSegment.UUID)
+	if target == nil || next == nil || stage.asrOutputObject.QueryPrevious(next) != target ||
+		target.Text != before[0].Text || target.Translated != before[0].Translated ||
+		next.Text != before[1].Text || next.Translated != before[1].Translated {
+		return errors.Errorf("segment %v or %v is changed while merging", segment.UUID, nextSegment.UUID)
 	}
 
 	// Remove the next, after merged to target.
+	*target = merged
 	stage.asrOutputObject.RemoveSegment(next)
 
 	if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
@@ -1051,6 +1707,10 @@ func handleStageMerge(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	}
 	logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
 
+	if err := stage.Record(OperationMerge, "user", before, copySegments(target)); err != nil {
+		return errors.Wrapf(err, "record")
+	}
+
 	ohttp.WriteData(ctx, w, r, &struct {
 		Segment *AudioSegment `json:"segment"`
 	}{
@@ -1059,12 +1719,20 @@ func handleStageMerge(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	return nil
 }
 
-func handleStageExport(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+func handleStageSplit(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var sid string
+	var segment AudioSegment
+	var offset int
+	var at float64
 	if err := ParseBody(ctx, r.Body, &struct {
-		SID *string `json:"sid"`
+		SID     *string       `json:"sid"`
+		Segment *AudioSegment `json:"segment"`
+		// The rune offset of text to split at, optional.
+		Offset *int `json:"offset"`
+		// The time in seconds to split at, used if no offset.
+		Time *float64 `json:"time"`
 	}{
-		SID: &sid,
+		SID: &sid, Segment: &segment, Offset: &offset, Time: &at,
 	}); err != nil {
 		return errors.Wrapf(err, "parse body")
 	}
@@ -1075,114 +1743,394 @@ func handleStageExport(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	}
 	ctx = stage.loggingCtx
 
-	audioFilename := fmt.Sprintf("audio-%v.wav", stage.SID)
-	audioFile := path.Join(stage.MainDir, audioFilename)
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
 
-	f, err := os.Create(audioFile)
-	if err != nil {
-		return errors.Wrapf(err, "create %v", audioFile)
+	target := stage.asrOutputObject.QuerySegment(segment.UUID)
+	if target == nil {
+		return errors.Errorf("no segment %v", segment.UUID)
 	}
-	defer f.Close()
 
-	// 100KHZ, each frame is 10ms.
-	buf := &audio.IntBuffer{Data: make([]int, 100000*48), Format: &audio.Format{SampleRate: 100000, NumChannels: 1}}
-	enc := wav.NewEncoder(f, buf.Format.SampleRate, 16, buf.Format.NumChannels, 1)
-	defer enc.Close()
+	before := copySegments(target)
+	first, second, err := stage.asrOutputObject.SplitSegment(target, offset, at)
+	if err != nil {
+		return errors.Wrapf(err, "split %v, offset=%v, time=%v", target.UUID, offset, at)
+	}
+	logger.Tf(ctx, "Split segment %v to %v(%v~%v) and %v(%v~%v)", target.UUID,
+		first.UUID, first.Start, first.End, second.UUID, second.Start, second.End)
 
-	insertSilent := func(duration float64) error {
-		if duration >= 0.01 {
-			logger.Tf(ctx, "Write wav ok, silent=%v", duration)
-			return enc.Write(&audio.IntBuffer{
-				Data:   make([]int, int(100000*duration)),
-				Format: &audio.Format{SampleRate: 100000, NumChannels: 1},
-			})
-		}
-		return nil
+	if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
+		return errors.Wrapf(err, "save")
 	}
+	logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
 
-	var previous *AudioSegment
-	for _, segment := range stage.asrOutputObject.Segments {
-		var gap float64
-		if previous != nil {
-			gap = segment.Start - previous.End
-		}
-		previous = segment
-		logger.Tf(ctx, "Handle segment %v, time %v~%v", segment.UUID, segment.Start, segment.End)
+	if err := stage.Record(OperationSplit, "user", before, copySegments(first, second)); err != nil {
+		return errors.Wrapf(err, "record")
+	}
 
-		if err := insertSilent(gap); err != nil {
-			return errors.Wrapf(err, "insert silent %v", gap)
-		}
+	ohttp.WriteData(ctx, w, r, &struct {
+		Segments []*AudioSegment `json:"segments"`
+	}{
+		Segments: []*AudioSegment{first, second},
+	})
+	return nil
+}
 
-		if segment.TTS == "" || segment.Removed {
-			if err := insertSilent(segment.End - segment.Start); err != nil {
-				return errors.Wrapf(err, "insert silent %v", segment.End-segment.Start)
-			}
-			continue
-		}
-
-		var wavDuration float64
-		if err := func() error {
-			ttsFile := path.Join(stage.MainDir, segment.TTS)
-			wavFile := path.Join(stage.MainDir, fmt.Sprintf("tts-%v.wav", segment.UUID))
-			logger.Tf(ctx, "Convert tts %v to wav", ttsFile)
-			if true {
-				if err := exec.CommandContext(ctx, "ffmpeg",
-					"-i", ttsFile,
-					"-vn", "-c:a", "pcm_s16le", "-ac", "1", "-ar", "100000", "-ab", "300k",
-					"-y", wavFile,
-				).Run(); err != nil {
-					return errors.Errorf("Error converting the file")
-				}
-			}
+func handleStageResegment(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	var revert bool
+	opts := ResegmentOptions{Pause: 0.8}
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+		// Whether revert to the archived segments.
+		Revert *bool `json:"revert"`
+		*ResegmentOptions
+	}{
+		SID: &sid, Revert: &revert, ResegmentOptions: &opts,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
 
-			wf, err := os.Open(wavFile)
-			if err != nil {
-				return errors.Wrapf(err, "open %v", wavFile)
-			}
-			defer wf.Close()
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
 
-			dec := wav.NewDecoder(wf)
-			bufWav, err := dec.FullPCMBuffer()
-			if err != nil {
-				return errors.Wrapf(err, "decode %v", wavFile)
-			}
-			if err = enc.Write(bufWav); err != nil {
-				return errors.Wrapf(err, "write %v", wavFile)
-			}
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
 
-			wavDuration = float64(len(bufWav.Data)) / 100000.
-			logger.Tf(ctx, "Write wav ok, duration=%v, data=%.3f", segment.TTSDuration, wavDuration)
-			return nil
-		}(); err != nil {
-			return errors.Wrapf(err, "merge")
-		}
+	if stage.asrOutputObject == nil {
+		return errors.Errorf("no asr object of %v", sid)
+	}
 
-		if err := insertSilent(segment.End - segment.Start - wavDuration); err != nil {
-			return errors.Wrapf(err, "insert silent %v", segment.End-segment.Start-wavDuration)
+	kind, before := OperationResegment, copySegments(stage.asrOutputObject.Segments...)
+	if revert {
+		kind = OperationRevert
+		if err := stage.asrOutputObject.Revert(); err != nil {
+			return errors.Wrapf(err, "revert")
 		}
+		logger.Tf(ctx, "Revert segments ok, segments=%v", len(stage.asrOutputObject.Segments))
+	} else {
+		previous := len(stage.asrOutputObject.Segments)
+		stage.asrOutputObject.Resegment(&opts)
+		logger.Tf(ctx, "Resegment ok, opts=%+v, segments %v=>%v", opts, previous, len(stage.asrOutputObject.Segments))
 	}
 
-	enc.Close()
-	logger.Tf(ctx, "All segments are converted")
+	if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
+		return errors.Wrapf(err, "save")
+	}
+	logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
 
-	aacFilename := fmt.Sprintf("audio-%v.mp4", stage.SID)
-	aacFile := path.Join(stage.MainDir, aacFilename)
-	if true {
-		if err := exec.CommandContext(ctx, "ffmpeg",
-			"-i", audioFile,
-			"-vn", "-c:a", "aac", "-ac", "2", "-ar", "44100", "-ab", "120k",
-			"-y", aacFile,
-		).Run(); err != nil {
-			return errors.Errorf("Error converting the file")
-		}
-		logger.Tf(ctx, "Convert to aac %v ok", aacFile)
+	if err := stage.Record(kind, "user", before, copySegments(stage.asrOutputObject.Segments...)); err != nil {
+		return errors.Wrapf(err, "record")
+	}
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		SID string         `json:"sid"`
+		ASR *AudioResponse `json:"asr"`
+	}{
+		SID: stage.SID, ASR: stage.asrOutputObject,
+	})
+	return nil
+}
+
+func handleStageUndo(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return doStageUndo(ctx, w, r, false)
+}
+
+func handleStageRedo(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return doStageUndo(ctx, w, r, true)
+}
+
+// Undo the last operation of segments, or redo the last undone operation.
+func doStageUndo(ctx context.Context, w http.ResponseWriter, r *http.Request, redo bool) error {
+	var sid string
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+	}{
+		SID: &sid,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	if stage.asrOutputObject == nil {
+		return errors.Errorf("no asr object of %v", sid)
+	}
+
+	oplog, err := stage.QueryOpLog()
+	if err != nil {
+		return errors.Wrapf(err, "oplog")
+	}
+
+	var op *Operation
+	if redo {
+		op, err = oplog.Redo(stage.asrOutputObject)
+	} else {
+		op, err = oplog.Undo(stage.asrOutputObject)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "undo, redo=%v", redo)
+	}
+	logger.Tf(ctx, "Undo operation %v %v by %v ok, redo=%v", op.ID, op.Kind, op.By, redo)
+
+	if err := stage.asrOutputObject.Save(stage.asrOutputJSON); err != nil {
+		return errors.Wrapf(err, "save")
+	}
+	logger.Tf(ctx, "Save ASR output to %v ok", stage.asrOutputJSON)
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		SID       string         `json:"sid"`
+		Operation *Operation     `json:"operation"`
+		ASR       *AudioResponse `json:"asr"`
+	}{
+		SID: stage.SID, Operation: op, ASR: stage.asrOutputObject,
+	})
+	return nil
+}
+
+func handleStageHistory(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	var segment AudioSegment
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID     *string       `json:"sid"`
+		Segment *AudioSegment `json:"segment"`
+	}{
+		SID: &sid, Segment: &segment,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	oplog, err := stage.QueryOpLog()
+	if err != nil {
+		return errors.Wrapf(err, "oplog")
+	}
+
+	history := oplog.History(segment.UUID)
+	logger.Tf(ctx, "Query history of %v ok, changes=%v", segment.UUID, len(history))
+
+	ohttp.WriteData(ctx, w, r, &struct {
+		History []*SegmentHistory `json:"history"`
+	}{
+		History: history,
+	})
+	return nil
+}
+
+func handleStageExport(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	var async bool
+	opts := ExportOptions{
+		Mode: ExportAudio, Container: "mp4", Language: os.Getenv("VODT_EXPORT_LANGUAGE"), OriginalLanguage: "und",
+		SubtitleMode: SubtitleTranslated, DuckLevel: -15, Fade: 0.3,
+	}
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+		// Whether response the job immediately, then download the result when job done.
+		Async *bool `json:"async"`
+		*ExportOptions
+	}{
+		SID: &sid, Async: &async, ExportOptions: &opts,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+	if opts.Mode != ExportAudio && opts.Mode != ExportVideo {
+		return errors.Errorf("invalid mode %v", opts.Mode)
+	}
+	if opts.Container != "mp4" && opts.Container != "mkv" {
+		return errors.Errorf("invalid container %v", opts.Container)
+	}
+	if opts.Subtitle != "" && opts.Subtitle != ExportSubtitleSoft && opts.Subtitle != ExportSubtitleBurn {
+		return errors.Errorf("invalid subtitle %v", opts.Subtitle)
+	}
+	if opts.DuckLevel > 0 || opts.Fade < 0 {
+		return errors.Errorf("invalid duck level %v or fade %v", opts.DuckLevel, opts.Fade)
+	}
+	if opts.Subtitle != "" && opts.Mode != ExportVideo {
+		return errors.Errorf("subtitle %v requires video mode", opts.Subtitle)
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	if (opts.Mode == ExportVideo || opts.Mix) && stage.InputURL == "" {
+		return errors.Errorf("no input video of %v", sid)
+	}
+
+	job := translatorServer.jobs.Start(ctx, stage, "export", 0, func(job *Job) error {
+		return doExport(ctx, stage, job, &opts)
+	})
+
+	if async {
+		ohttp.WriteData(ctx, w, r, &struct {
+			SID string `json:"sid"`
+			Job *Job   `json:"job"`
+		}{
+			SID: stage.SID, Job: job.Snapshot(),
+		})
+		return nil
+	}
+
+	if err := job.Wait(r.Context()); err != nil {
+		return errors.Wrapf(err, "export")
+	}
+	result := job.Snapshot().Result
+	logger.Tf(ctx, "Download %v ok", result)
+
+	http.ServeFile(w, r, path.Join(stage.MainDir, result))
+	return nil
+}
+
+func handleStageSubtitle(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	format, mode := SubtitleSRT, SubtitleTranslated
+	if err := ParseBody(ctx, r.Body, &struct {
+		SID *string `json:"sid"`
+		// The subtitle format, srt or vtt.
+		Format *string `json:"format"`
+		// The text of subtitle, source, translated or bilingual.
+		Mode *string `json:"mode"`
+	}{
+		SID: &sid, Format: &format, Mode: &mode,
+	}); err != nil {
+		return errors.Wrapf(err, "parse body")
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	if stage.asrOutputObject == nil {
+		return errors.Errorf("no asr object of %v", sid)
+	}
+
+	subtitle, err := stage.asrOutputObject.Subtitle(format, mode)
+	if err != nil {
+		return errors.Wrapf(err, "subtitle")
+	}
+	logger.Tf(ctx, "Export subtitle ok, format=%v, mode=%v, size=%vB", format, mode, len(subtitle))
+
+	if format == SubtitleVTT {
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-subrip; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"subtitle-%v-%v.%v\"", stage.SID, mode, format))
+	_, _ = w.Write([]byte(subtitle))
+	return nil
+}
+
+func handleStageDownload(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	ss := strings.Split(r.URL.Path[len("/api/vod-translator/download/"):], "/")
+	if len(ss) < 2 {
+		return errors.Errorf("invalid path %v", r.URL.Path)
 	}
-	logger.Tf(ctx, "Download AAC ok")
+	sid, filename := ss[0], path.Base(ss[1])
 
-	http.ServeFile(w, r, aacFile)
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	// Only the exported files are allowed to download.
+	if !strings.HasPrefix(filename, "audio-") && !strings.HasPrefix(filename, "video-") {
+		return errors.Errorf("invalid file %v", filename)
+	}
+	logger.Tf(ctx, "Download %v of %v", filename, sid)
+
+	http.ServeFile(w, r, path.Join(stage.MainDir, filename))
 	return nil
 }
 
+func handleStageEvents(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	sid := r.URL.Query().Get("sid")
+	if sid == "" {
+		sid = strings.Trim(r.URL.Path[len("/api/vod-translator/events/"):], "/")
+	}
+
+	stage := translatorServer.QueryStage(sid)
+	if stage == nil {
+		return errors.Errorf("no stage %v", sid)
+	}
+	ctx = stage.loggingCtx
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.Errorf("streaming not supported")
+	}
+
+	events := translatorServer.jobs.Subscribe(sid)
+	defer translatorServer.jobs.Unsubscribe(sid, events)
+	logger.Tf(ctx, "Subscribe events of %v", sid)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	writeEvent := func(event *JobEvent) error {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrapf(err, "marshal")
+		}
+		if _, err := fmt.Fprintf(w, "event: %v\ndata: %v\n\n", event.Type, string(b)); err != nil {
+			return errors.Wrapf(err, "write")
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	// Send the current state of all jobs of project.
+	for _, job := range translatorServer.jobs.QueryProject(sid) {
+		if err := writeEvent(&JobEvent{Type: "progress", SID: sid, JobID: job.ID, Job: job.Snapshot()}); err != nil {
+			return errors.Wrapf(err, "write event")
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			logger.Tf(ctx, "Unsubscribe events of %v", sid)
+			return nil
+		case <-time.After(15 * time.Second):
+			if _, err := fmt.Fprintf(w, ": keepalive\n\n"); err != nil {
+				return errors.Wrapf(err, "write")
+			}
+			flusher.Flush()
+		case event := <-events:
+			if err := writeEvent(event); err != nil {
+				return errors.Wrapf(err, "write event")
+			}
+		}
+	}
+}
+
 func doMain(ctx context.Context) error {
 	if err := doConfig(ctx); err != nil {
 		return errors.Wrapf(err, "config")
@@ -1199,6 +2147,8 @@ func doMain(ctx context.Context) error {
 	translatorServer = NewTranslatorServer()
 	defer translatorServer.Close()
 
+	go runSweeper(ctx)
+
 	fs := http.FileServer(http.Dir("./static"))
 	http.HandleFunc("/api/vod-translator/resources/", func(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = r.URL.Path[len("/api/vod-translator/resources/"):]
@@ -1212,6 +2162,27 @@ func doMain(ctx context.Context) error {
 		}
 	})
 
+	http.HandleFunc("/api/vod-translator/project-list/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleProjectList(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/project-delete/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleProjectDelete(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/project-rename/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleProjectRename(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
 	http.HandleFunc("/api/vod-translator/load/", func(w http.ResponseWriter, r *http.Request) {
 		if err := handleStageLoad(ctx, w, r); err != nil {
 			logger.Tf(ctx, "error: %+v", err)
@@ -1219,6 +2190,13 @@ func doMain(ctx context.Context) error {
 		}
 	})
 
+	http.HandleFunc("/api/vod-translator/settings/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageSettings(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
 	http.HandleFunc("/api/vod-translator/asr/", func(w http.ResponseWriter, r *http.Request) {
 		if err := handleStageAsr(ctx, w, r); err != nil {
 			logger.Tf(ctx, "error: %+v", err)
@@ -1226,6 +2204,13 @@ func doMain(ctx context.Context) error {
 		}
 	})
 
+	http.HandleFunc("/api/vod-translator/import/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageImport(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
 	http.HandleFunc("/api/vod-translator/asr-update/", func(w http.ResponseWriter, r *http.Request) {
 		if err := handleStageAsrUpdate(ctx, w, r); err != nil {
 			logger.Tf(ctx, "error: %+v", err)
@@ -1240,6 +2225,27 @@ func doMain(ctx context.Context) error {
 		}
 	})
 
+	http.HandleFunc("/api/vod-translator/translate-fit/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageTranslateFit(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/translate-all/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageTranslateAll(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/job/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleJobQuery(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
 	http.HandleFunc("/api/vod-translator/shorter/", func(w http.ResponseWriter, r *http.Request) {
 		if err := handleStageShorter(ctx, w, r); err != nil {
 			logger.Tf(ctx, "error: %+v", err)
@@ -1254,6 +2260,41 @@ func doMain(ctx context.Context) error {
 		}
 	})
 
+	http.HandleFunc("/api/vod-translator/split/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageSplit(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/resegment/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageResegment(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/undo/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageUndo(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/redo/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageRedo(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/history/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageHistory(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
 	http.HandleFunc("/api/vod-translator/tts/", func(w http.ResponseWriter, r *http.Request) {
 		if err := handleStageTTS(ctx, w, r); err != nil {
 			logger.Tf(ctx, "error: %+v", err)
@@ -1261,6 +2302,27 @@ func doMain(ctx context.Context) error {
 		}
 	})
 
+	http.HandleFunc("/api/vod-translator/tts-all/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageTTSAll(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/separate/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageSeparate(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/fit/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageFit(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
 	http.HandleFunc("/api/vod-translator/preview/", func(w http.ResponseWriter, r *http.Request) {
 		if err := handleStagePreview(ctx, w, r); err != nil {
 			logger.Tf(ctx, "error: %+v", err)
@@ -1275,6 +2337,27 @@ func doMain(ctx context.Context) error {
 		}
 	})
 
+	http.HandleFunc("/api/vod-translator/subtitle/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageSubtitle(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/download/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageDownload(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	http.HandleFunc("/api/vod-translator/events/", func(w http.ResponseWriter, r *http.Request) {
+		if err := handleStageEvents(ctx, w, r); err != nil {
+			logger.Tf(ctx, "error: %+v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
 	if err := http.ListenAndServe(":3001", nil); err != nil {
 		return errors.Wrap(err, "http serve")
 	}
@@ -1292,20 +2375,63 @@ func doConfig(ctx context.Context) error {
 	setEnvDefault("OPENAI_API_KEY", "")
 	setEnvDefault("OPENAI_PROXY", "https://api.openai.com/v1")
 	setEnvDefault("VODT_ASR_LANGUAGE", DefaultAsrLanguage)
+	setEnvDefault("VODT_ASR_PROVIDER", "openai")
+	setEnvDefault("VODT_WHISPER_BIN", "")
+	setEnvDefault("VODT_WHISPER_MODEL", "")
+	setEnvDefault("VODT_ASR_WORKERS", "3")
+	setEnvDefault("VODT_ASR_SILENCE_NOISE", "-30dB")
+	setEnvDefault("VODT_ASR_SILENCE_DURATION", "0.5")
 	setEnvDefault("VODT_CHAT_PROMPT", DefaultTranslatePrompt)
 	setEnvDefault("VODT_CHAT_MODEL", openai.GPT3Dot5Turbo1106)
+	setEnvDefault("VODT_TRANSLATOR", "openai")
+	setEnvDefault("VODT_DEEPL_URL", "https://api-free.deepl.com/v2/translate")
+	setEnvDefault("VODT_DEEPL_TARGET_LANG", "ZH")
 	setEnvDefault("VODT_SHORTER_MODEL", openai.GPT4TurboPreview)
 	setEnvDefault("VODT_SHORTER_PROMPT", DefaultShorterPrompt)
 	setEnvDefault("VODT_TTS_PROVIDER", "openai")
 	setEnvDefault("VODT_11LABS_KEY", "")
 	setEnvDefault("VODT_11LABS_VOICE", "")
+	setEnvDefault("VODT_LOCAL_TTS", "piper")
+	setEnvDefault("VODT_PIPER_BIN", "piper")
+	setEnvDefault("VODT_PIPER_MODEL", "")
+	setEnvDefault("VODT_ESPEAK_BIN", "espeak-ng")
+	setEnvDefault("VODT_ESPEAK_VOICE", "zh")
+	setEnvDefault("VODT_TTS_CONCURRENCY", "openai=3,11labs=2,local=1")
+	setEnvDefault("VODT_FIT_MIN_TEMPO", "1.0")
+	setEnvDefault("VODT_FIT_MAX_TEMPO", "1.3")
+	setEnvDefault("VODT_SPEAKING_RATE", "4.5")
+	setEnvDefault("VODT_EXPORT_LANGUAGE", "chi")
+	setEnvDefault("VODT_SEPARATE_CMD", "demucs --two-stems=vocals -o {output} {input}")
+	setEnvDefault("VODT_SEPARATE_STEM", "htdemucs/{name}/no_vocals.wav")
+	setEnvDefault("VODT_UNLOAD_TTL", "72h")
+	setEnvDefault("VODT_DELETE_TTL", "0")
+	setEnvDefault("VODT_PROJECT_QUOTA", "0")
+	setEnvDefault("VODT_SERVER_QUOTA", "0")
+	setEnvDefault("VODT_SWEEP_INTERVAL", "10m")
+	setEnvDefault("VODT_JSON_BACKUPS", "3")
 	logger.Tf(ctx, "Environment variables: OPENAI_API_KEY=%vB, OPENAI_PROXY=%v, VODT_ASR_LANGUAGE=%v, VODT_CHAT_PROMPT=%v, "+
 		"VODT_CHAT_MODEL=%v, VODT_SHORTER_MODEL=%v, VODT_11LABS_KEY=%vB, VODT_TTS_PROVIDER=%v, VODT_SHORTER_PROMPT=%v, "+
-		"VODT_11LABS_VOICE=%v",
+		"VODT_11LABS_VOICE=%v, VODT_ASR_PROVIDER=%v, VODT_WHISPER_BIN=%v, VODT_WHISPER_MODEL=%v, VODT_TRANSLATOR=%v, "+
+		"VODT_TRANSLATE_BASE_URL=%v, VODT_TRANSLATE_MODEL=%v, VODT_DEEPL_URL=%v, VODT_DEEPL_TARGET_LANG=%v, VODT_DICT_FILE=%v, "+
+		"VODT_LOCAL_TTS=%v, VODT_PIPER_BIN=%v, VODT_PIPER_MODEL=%v, VODT_ESPEAK_BIN=%v, VODT_ESPEAK_VOICE=%v, "+
+		"VODT_ASR_WORKERS=%v, VODT_ASR_SILENCE_NOISE=%v, VODT_ASR_SILENCE_DURATION=%v, VODT_TTS_CONCURRENCY=%v, "+
+		"VODT_FIT_MIN_TEMPO=%v, VODT_FIT_MAX_TEMPO=%v, VODT_SPEAKING_RATE=%v, VODT_EXPORT_LANGUAGE=%v, "+
+		"VODT_SEPARATE_CMD=%v, VODT_SEPARATE_STEM=%v, VODT_UNLOAD_TTL=%v, VODT_DELETE_TTL=%v, "+
+		"VODT_PROJECT_QUOTA=%v, VODT_SERVER_QUOTA=%v, VODT_SWEEP_INTERVAL=%v, VODT_JSON_BACKUPS=%v",
 		len(os.Getenv("OPENAI_API_KEY")), os.Getenv("OPENAI_PROXY"), os.Getenv("VODT_ASR_LANGUAGE"),
 		os.Getenv("VODT_CHAT_PROMPT"), os.Getenv("VODT_CHAT_MODEL"), os.Getenv("VODT_SHORTER_MODEL"),
 		len(os.Getenv("VODT_11LABS_KEY")), os.Getenv("VODT_TTS_PROVIDER"), os.Getenv("VODT_SHORTER_PROMPT"),
-		os.Getenv("VODT_11LABS_VOICE"),
+		os.Getenv("VODT_11LABS_VOICE"), os.Getenv("VODT_ASR_PROVIDER"), os.Getenv("VODT_WHISPER_BIN"),
+		os.Getenv("VODT_WHISPER_MODEL"), os.Getenv("VODT_TRANSLATOR"), os.Getenv("VODT_TRANSLATE_BASE_URL"),
+		os.Getenv("VODT_TRANSLATE_MODEL"), os.Getenv("VODT_DEEPL_URL"), os.Getenv("VODT_DEEPL_TARGET_LANG"),
+		os.Getenv("VODT_DICT_FILE"), os.Getenv("VODT_LOCAL_TTS"), os.Getenv("VODT_PIPER_BIN"),
+		os.Getenv("VODT_PIPER_MODEL"), os.Getenv("VODT_ESPEAK_BIN"), os.Getenv("VODT_ESPEAK_VOICE"),
+		os.Getenv("VODT_ASR_WORKERS"), os.Getenv("VODT_ASR_SILENCE_NOISE"), os.Getenv("VODT_ASR_SILENCE_DURATION"),
+		os.Getenv("VODT_TTS_CONCURRENCY"), os.Getenv("VODT_FIT_MIN_TEMPO"), os.Getenv("VODT_FIT_MAX_TEMPO"),
+		os.Getenv("VODT_SPEAKING_RATE"), os.Getenv("VODT_EXPORT_LANGUAGE"),
+		os.Getenv("VODT_SEPARATE_CMD"), os.Getenv("VODT_SEPARATE_STEM"), os.Getenv("VODT_UNLOAD_TTL"),
+		os.Getenv("VODT_DELETE_TTL"), os.Getenv("VODT_PROJECT_QUOTA"), os.Getenv("VODT_SERVER_QUOTA"),
+		os.Getenv("VODT_SWEEP_INTERVAL"), os.Getenv("VODT_JSON_BACKUPS"),
 	)
 
 	// Load env variables from file.
@@ -1314,8 +2440,31 @@ func doConfig(ctx context.Context) error {
 			return errors.Wrapf(err, "load env")
 		}
 	}
+	// The key is only required by the OpenAI ASR, TTS and translator, not for the offline setup.
 	if os.Getenv("OPENAI_API_KEY") == "" {
-		return errors.New("OPENAI_API_KEY is required")
+		for _, key := range []string{"VODT_ASR_PROVIDER", "VODT_TTS_PROVIDER", "VODT_TRANSLATOR"} {
+			if os.Getenv(key) == "openai" {
+				return errors.Errorf("OPENAI_API_KEY is required by %v=openai", key)
+			}
+		}
+	}
+	if os.Getenv("VODT_ASR_PROVIDER") == "whisper.cpp" || os.Getenv("VODT_ASR_PROVIDER") == "faster-whisper" {
+		if os.Getenv("VODT_WHISPER_MODEL") == "" {
+			return errors.New("VODT_WHISPER_MODEL is required")
+		}
+	} else if os.Getenv("VODT_ASR_PROVIDER") != "openai" {
+		return errors.Errorf("Unknown ASR provider %v", os.Getenv("VODT_ASR_PROVIDER"))
+	}
+	if QueryTTSProvider(os.Getenv("VODT_TTS_PROVIDER")) == nil {
+		return errors.Errorf("Unknown TTS provider %v", os.Getenv("VODT_TTS_PROVIDER"))
+	}
+	if os.Getenv("VODT_TTS_PROVIDER") == "local" && os.Getenv("VODT_LOCAL_TTS") == "piper" {
+		if os.Getenv("VODT_PIPER_MODEL") == "" {
+			return errors.New("VODT_PIPER_MODEL is required")
+		}
+	}
+	if _, _, err := fitTempoBounds(); err != nil {
+		return errors.Wrapf(err, "fit tempo")
 	}
 	if os.Getenv("VODT_TTS_PROVIDER") == "11labs" {
 		if os.Getenv("VODT_11LABS_KEY") == "" {
@@ -1332,5 +2481,10 @@ func doConfig(ctx context.Context) error {
 	logger.Tf(ctx, "OpenAI key(OPENAI_API_KEY): %vB, proxy(OPENAI_PROXY): %v, base url: %v",
 		len(os.Getenv("OPENAI_API_KEY")), os.Getenv("OPENAI_PROXY"), aiConfig.BaseURL)
 
+	// Check the default translate engine.
+	if _, err := NewTranslator(os.Getenv("VODT_TRANSLATOR")); err != nil {
+		return errors.Wrapf(err, "translator %v", os.Getenv("VODT_TRANSLATOR"))
+	}
+
 	return nil
 }
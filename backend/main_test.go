@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// The TTS provider for tests, which writes a short silent WAV.
+type testTTSProvider struct{}
+
+func (v *testTTSProvider) Synthesize(ctx context.Context, text, filename string) error {
+	w, err := newWavWriter(filename)
+	if err != nil {
+		return err
+	}
+	if err := w.WriteSilence(0.1); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (v *testTTSProvider) Container() string {
+	return "wav"
+}
+
+func (v *testTTSProvider) ContentType() string {
+	return "audio/wav"
+}
+
+// Setup the server in a temporary work dir, with the dict translator and the test TTS provider.
+func setupTestServer(t *testing.T) {
+	workDir = t.TempDir()
+	translatorServer = NewTranslatorServer()
+	RegisterTTSProvider("test", &testTTSProvider{})
+
+	dictFile := path.Join(workDir, "dict.json")
+	if err := os.WriteFile(dictFile, []byte(`{"hello": "你好", "world": "世界"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"VODT_TRANSLATOR": "dict", "VODT_DICT_FILE": dictFile, "VODT_TTS_PROVIDER": "test",
+		"VODT_UNLOAD_TTL": "0", "VODT_JSON_BACKUPS": "3",
+	} {
+		t.Setenv(k, v)
+	}
+}
+
+// Call the handler with the body in JSON.
+func callHandler(handler func(context.Context, http.ResponseWriter, *http.Request) error, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+	return handler(context.Background(), httptest.NewRecorder(), r)
+}
+
+// Import a subtitle of n cues to the project sid.
+func importTestSubtitle(t *testing.T, sid string, n int) {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		sb.WriteString(fmt.Sprintf("%v\n%v --> %v\nhello world number %v\n\n", i+1,
+			formatSubtitleTime(float64(i*2), SubtitleSRT), formatSubtitleTime(float64(i*2+2), SubtitleSRT), i))
+	}
+
+	if err := callHandler(handleStageImport, map[string]string{"sid": sid, "content": sb.String()}); err != nil {
+		t.Fatalf("import err %+v", err)
+	}
+}
+
+// Check the segments of project are unique, in time order, and the same as saved.
+func checkTestSegments(t *testing.T, stage *Project) {
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	visited := make(map[string]bool)
+	for i, segment := range stage.asrOutputObject.Segments {
+		if visited[segment.UUID] {
+			t.Errorf("duplicated segment %v", segment.UUID)
+		}
+		visited[segment.UUID] = true
+
+		if i > 0 && segment.Start < stage.asrOutputObject.Segments[i-1].Start {
+			t.Errorf("segment %v starts at %v, before the previous", segment.UUID, segment.Start)
+		}
+	}
+
+	saved := &AudioResponse{}
+	if err := saved.Load(stage.asrOutputJSON); err != nil {
+		t.Fatalf("load err %+v", err)
+	}
+	if len(saved.Segments) != len(stage.asrOutputObject.Segments) {
+		t.Fatalf("saved %v segments, but %v in memory", len(saved.Segments), len(stage.asrOutputObject.Segments))
+	}
+	for i, segment := range saved.Segments {
+		if expect := stage.asrOutputObject.Segments[i]; segment.UUID != expect.UUID || segment.Translated != expect.Translated {
+			t.Errorf("saved segment %v is %v<%v>, but %v<%v> in memory", i, segment.UUID, segment.Translated,
+				expect.UUID, expect.Translated)
+		}
+	}
+}
+
+func TestConcurrentLoad(t *testing.T) {
+	setupTestServer(t)
+	sid := uuid.NewString()
+	importTestSubtitle(t, sid, 3)
+
+	// Unload the project, then load it concurrently.
+	translatorServer.RemoveStage(translatorServer.QueryStage(sid))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := callHandler(handleStageLoad, map[string]string{"sid": sid}); err != nil {
+				t.Errorf("load err %+v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var stages int
+	for _, stage := range translatorServer.stages {
+		if stage.SID == sid {
+			stages++
+		}
+	}
+	if stages != 1 {
+		t.Fatalf("loaded %v stages of %v", stages, sid)
+	}
+}
+
+func TestConcurrentEdits(t *testing.T) {
+	setupTestServer(t)
+	sid := uuid.NewString()
+	importTestSubtitle(t, sid, 40)
+	stage := translatorServer.QueryStage(sid)
+
+	// Pick a random segment, and the next one, which may be changed by other requests.
+	pick := func(r *rand.Rand) (*AudioSegment, *AudioSegment) {
+		segments := stage.CopySegments()
+		i := r.Intn(len(segments) - 1)
+		return segments[i], segments[i+1]
+	}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(worker)))
+
+			// The requests fail if the segment is merged or split by others, which is expected.
+			for i := 0; i < 30; i++ {
+				segment, next := pick(r)
+				switch i % 5 {
+				case 0:
+					callHandler(handleStageLoad, map[string]string{"sid": sid})
+				case 1:
+					callHandler(handleStageAsrUpdate, map[string]interface{}{"sid": sid, "segment": &AudioSegment{
+						UUID: segment.UUID, Text: segment.Text, Translated: fmt.Sprintf("edit %v-%v", worker, i),
+					}})
+				case 2:
+					callHandler(handleStageTranslate, map[string]interface{}{"sid": sid, "segment": segment})
+				case 3:
+					callHandler(handleStageMerge, map[string]interface{}{"sid": sid, "segment": segment, "next": next})
+				case 4:
+					callHandler(handleStageSplit, map[string]interface{}{"sid": sid, "segment": segment, "offset": 6})
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	checkTestSegments(t, stage)
+}
+
+func TestRemoveStaleStage(t *testing.T) {
+	setupTestServer(t)
+	sid := uuid.NewString()
+	importTestSubtitle(t, sid, 3)
+
+	// Unload the project and load it again, then remove the stale stage.
+	stale := translatorServer.QueryStage(sid)
+	translatorServer.RemoveStage(stale)
+	if err := callHandler(handleStageLoad, map[string]string{"sid": sid}); err != nil {
+		t.Fatalf("load err %+v", err)
+	}
+	translatorServer.RemoveStage(stale)
+
+	if stage := translatorServer.QueryStage(sid); stage == nil || stage == stale {
+		t.Fatalf("the loaded stage is removed by the stale one")
+	}
+}
+
+func TestDeleteProject(t *testing.T) {
+	setupTestServer(t)
+	sid := uuid.NewString()
+	importTestSubtitle(t, sid, 3)
+	stage := translatorServer.QueryStage(sid)
+
+	if err := callHandler(handleProjectDelete, map[string]string{"sid": sid}); err != nil {
+		t.Fatalf("delete err %+v", err)
+	}
+
+	// The request in flight never saves the deleted project.
+	stage.lock.Lock()
+	err := stage.Save()
+	stage.lock.Unlock()
+	if err == nil {
+		t.Errorf("save the deleted project")
+	}
+	if _, err := os.Stat(stage.MainDir); !os.IsNotExist(err) {
+		t.Errorf("project dir exists, err %v", err)
+	}
+
+	// The deleted project never starts jobs.
+	job := translatorServer.jobs.Start(context.Background(), stage, "tts", 0, func(job *Job) error {
+		return nil
+	})
+	if err := job.Wait(context.Background()); err == nil {
+		t.Errorf("start job %v of deleted project", job.ID)
+	}
+	if jobs := translatorServer.jobs.QueryProject(sid); len(jobs) != 0 {
+		t.Errorf("%v jobs of deleted project", len(jobs))
+	}
+}
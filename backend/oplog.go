@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+const (
+	OperationUpdate       = "update"
+	OperationTranslate    = "translate"
+	OperationTranslateFit = "translate-fit"
+	OperationShorter      = "shorter"
+	OperationMerge        = "merge"
+	OperationSplit        = "split"
+	OperationResegment    = "resegment"
+	OperationRevert       = "revert"
+	OperationImport       = "import"
+	OperationUndo         = "undo"
+	OperationRedo         = "redo"
+)
+
+// Operation is a mutation of segments, appended to the operation log of project. The segments
+// before the operation are replaced by the segments after, so undo is the reverse.
+type Operation struct {
+	// The operation ID.
+	ID string `json:"id"`
+	// The kind of operation, for example, update, translate, shorter, merge, undo or redo.
+	Kind string `json:"kind"`
+	// Who made the change, user, shorter or the translate engine.
+	By string `json:"by"`
+	// The ID of operation to undo or redo.
+	Target string `json:"target,omitempty"`
+	// The copies of segments before the operation.
+	Before []*AudioSegment `json:"before,omitempty"`
+	// The copies of segments after the operation.
+	After []*AudioSegment `json:"after,omitempty"`
+	// The create time.
+	Created AITime `json:"created"`
+}
+
+// SegmentHistory is a change of segment, for the history of segment.
+type SegmentHistory struct {
+	// The operation ID.
+	ID string `json:"id"`
+	// The kind of operation.
+	Kind string `json:"kind"`
+	// Who made the change.
+	By string `json:"by"`
+	// The segment before the change, nil if created by the operation.
+	Before *AudioSegment `json:"before"`
+	// The segment after the change, nil if removed by the operation.
+	After *AudioSegment `json:"after"`
+	// Whether the operation is undone.
+	Undone bool `json:"undone"`
+	// The create time.
+	Created AITime `json:"created"`
+}
+
+// OpLog is the append-only operation log of project, saved as JSON lines in oplog.jsonl. The undo
+// and redo are also appended, so the stacks are rebuilt by replaying the log. Note that it's
+// protected by the lock of project.
+type OpLog struct {
+	// The file of log.
+	filename string
+	// All operations in the log, except undo and redo.
+	operations []*Operation
+	// The operations applied, the last one is undone first.
+	applied []*Operation
+	// The operations undone, the last one is redone first.
+	undone []*Operation
+}
+
+func NewOpLog(dir string) *OpLog {
+	return &OpLog{filename: path.Join(dir, "oplog.jsonl")}
+}
+
+// Load the log from file, empty if not exists. The tail which is truncated or broken by crash is
+// never applied, and is removed from file, so that the next operation starts on a new line.
+func (v *OpLog) Load() error {
+	f, err := os.Open(v.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "open %v", v.filename)
+	}
+	defer f.Close()
+
+	// The offset of the last valid line, which ends with a newline.
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "read %v", v.filename)
+		}
+
+		var op Operation
+		if err := json.Unmarshal(line, &op); err != nil {
+			break
+		}
+		v.replay(&op)
+		offset += int64(len(line))
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat %v", v.filename)
+	}
+	if info.Size() > offset {
+		if err := os.Truncate(v.filename, offset); err != nil {
+			return errors.Wrapf(err, "truncate %v to %v", v.filename, offset)
+		}
+	}
+	return nil
+}
+
+// Replay the operation to the stacks.
+func (v *OpLog) replay(op *Operation) {
+	switch op.Kind {
+	case OperationUndo:
+		if n := len(v.applied); n > 0 && v.applied[n-1].ID == op.Target {
+			v.applied, v.undone = v.applied[:n-1], append(v.undone, v.applied[n-1])
+		}
+	case OperationRedo:
+		if n := len(v.undone); n > 0 && v.undone[n-1].ID == op.Target {
+			v.undone, v.applied = v.undone[:n-1], append(v.applied, v.undone[n-1])
+		}
+	default:
+		// A new operation discards the undone operations, which can't be redone.
+		v.operations = append(v.operations, op)
+		v.applied, v.undone = append(v.applied, op), nil
+	}
+}
+
+// Append the operation to file, then replay it.
+func (v *OpLog) append(op *Operation) error {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return errors.Wrapf(err, "marshal")
+	}
+
+	f, err := os.OpenFile(v.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.FileMode(0644))
+	if err != nil {
+		return errors.Wrapf(err, "open %v", v.filename)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return errors.Wrapf(err, "write %v", v.filename)
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Wrapf(err, "sync %v", v.filename)
+	}
+
+	v.replay(op)
+	return nil
+}
+
+// Record the operation, with the segments before and after, which should be copies.
+func (v *OpLog) Record(kind, by string, before, after []*AudioSegment) (*Operation, error) {
+	op := &Operation{
+		ID: uuid.NewString(), Kind: kind, By: by, Before: before, After: after, Created: AITime(time.Now()),
+	}
+	if err := v.append(op); err != nil {
+		return nil, errors.Wrapf(err, "append")
+	}
+	return op, nil
+}
+
+// Undo the last applied operation, by replacing the segments after by the segments before.
+func (v *OpLog) Undo(asr *AudioResponse) (*Operation, error) {
+	if len(v.applied) == 0 {
+		return nil, errors.New("nothing to undo")
+	}
+
+	op := v.applied[len(v.applied)-1]
+	if err := replaceSegments(asr, op.After, op.Before); err != nil {
+		return nil, errors.Wrapf(err, "undo %v %v", op.Kind, op.ID)
+	}
+	if err := v.append(&Operation{
+		ID: uuid.NewString(), Kind: OperationUndo, By: "user", Target: op.ID, Created: AITime(time.Now()),
+	}); err != nil {
+		return nil, errors.Wrapf(err, "append")
+	}
+	return op, nil
+}
+
+// Redo the last undone operation, by replacing the segments before by the segments after.
+func (v *OpLog) Redo(asr *AudioResponse) (*Operation, error) {
+	if len(v.undone) == 0 {
+		return nil, errors.New("nothing to redo")
+	}
+
+	op := v.undone[len(v.undone)-1]
+	if err := replaceSegments(asr, op.Before, op.After); err != nil {
+		return nil, errors.Wrapf(err, "redo %v %v", op.Kind, op.ID)
+	}
+	if err := v.append(&Operation{
+		ID: uuid.NewString(), Kind: OperationRedo, By: "user", Target: op.ID, Created: AITime(time.Now()),
+	}); err != nil {
+		return nil, errors.Wrapf(err, "append")
+	}
+	return op, nil
+}
+
+// History of segment by UUID, the changes which create, update or remove it, the oldest first.
+func (v *OpLog) History(uuid string) []*SegmentHistory {
+	applied := make(map[string]bool)
+	for _, op := range v.applied {
+		applied[op.ID] = true
+	}
+
+	find := func(segments []*AudioSegment) *AudioSegment {
+		for _, segment := range segments {
+			if segment.UUID == uuid {
+				return segment
+			}
+		}
+		return nil
+	}
+
+	history := []*SegmentHistory{}
+	for _, op := range v.operations {
+		before, after := find(op.Before), find(op.After)
+		if before == nil && after == nil {
+			continue
+		}
+		history = append(history, &SegmentHistory{
+			ID: op.ID, Kind: op.Kind, By: op.By, Before: before, After: after, Undone: !applied[op.ID],
+			Created: op.Created,
+		})
+	}
+	return history
+}
+
+// Replace the segments from by the copies of segments to, in time order. The restored segment
+// keeps the TTS of the segment in project, and is converted again if the translated text is
+// changed, or if it's not in project, because the TTS file is overwritten or removed. The update
+// time is only bumped if the text is changed, or if it's not in project.
+func replaceSegments(asr *AudioResponse, from, to []*AudioSegment) error {
+	if asr == nil {
+		return errors.New("no asr object")
+	}
+
+	current := make(map[string]*AudioSegment)
+	for _, segment := range from {
+		target := asr.QuerySegment(segment.UUID)
+		if target == nil {
+			return errors.Errorf("segment %v is changed by other operation", segment.UUID)
+		}
+		current[segment.UUID] = target
+	}
+	for _, segment := range to {
+		if current[segment.UUID] == nil && asr.QuerySegment(segment.UUID) != nil {
+			return errors.Errorf("segment %v is changed by other operation", segment.UUID)
+		}
+	}
+
+	for _, segment := range from {
+		asr.RemoveSegment(segment)
+	}
+
+	for _, segment := range to {
+		restored := *segment
+		if target := current[segment.UUID]; target != nil {
+			restored.TTS, restored.TTSAt, restored.TTSProvider = target.TTS, target.TTSAt, target.TTSProvider
+			restored.TTSDuration = target.TTSDuration
+			restored.Fitted, restored.FittedAt, restored.FitTempo = target.Fitted, target.FittedAt, target.FitTempo
+			restored.Overflow = target.Overflow
+			if restored.Text != target.Text {
+				restored.Update = AITime(time.Now())
+			}
+			if restored.Translated != target.Translated {
+				restored.TranslatedAt = AITime(time.Now())
+			}
+		} else {
+			// The TTS file may be removed by sweep, so convert it again.
+			restored.TTS, restored.TTSDuration, restored.Fitted = "", 0, ""
+			restored.Update = AITime(time.Now())
+		}
+		asr.Segments = append(asr.Segments, &restored)
+	}
+
+	sort.SliceStable(asr.Segments, func(i, j int) bool {
+		return asr.Segments[i].Start < asr.Segments[j].Start
+	})
+	return nil
+}
+
+// Copy the segments, to record them in the operation log.
+func copySegments(segments ...*AudioSegment) []*AudioSegment {
+	copies := make([]*AudioSegment, 0, len(segments))
+	for _, segment := range segments {
+		tmp := *segment
+		copies = append(copies, &tmp)
+	}
+	return copies
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// The IDs of operations, to compare the stacks.
+func buildOperationIDs(ops []*Operation) []string {
+	ids := []string{}
+	for _, op := range ops {
+		ids = append(ids, op.ID)
+	}
+	return ids
+}
+
+func checkOperationIDs(t *testing.T, name string, ops []*Operation, expect ...string) {
+	t.Helper()
+	ids := buildOperationIDs(ops)
+	if len(ids) != len(expect) {
+		t.Fatalf("%v is %v, expect %v", name, ids, expect)
+	}
+	for i := range ids {
+		if ids[i] != expect[i] {
+			t.Fatalf("%v is %v, expect %v", name, ids, expect)
+		}
+	}
+}
+
+func TestOpLogReplay(t *testing.T) {
+	v := &OpLog{}
+	for _, op := range []*Operation{
+		{ID: "a", Kind: OperationUpdate},
+		{ID: "b", Kind: OperationUpdate},
+		{ID: "u1", Kind: OperationUndo, Target: "b"},
+		// Ignore the undo of operation which is not the last applied.
+		{ID: "u2", Kind: OperationUndo, Target: "b"},
+		{ID: "r1", Kind: OperationRedo, Target: "b"},
+		{ID: "u3", Kind: OperationUndo, Target: "b"},
+		{ID: "u4", Kind: OperationUndo, Target: "a"},
+	} {
+		v.replay(op)
+	}
+	checkOperationIDs(t, "applied", v.applied)
+	checkOperationIDs(t, "undone", v.undone, "b", "a")
+	checkOperationIDs(t, "operations", v.operations, "a", "b")
+
+	// A new operation discards the undone operations.
+	v.replay(&Operation{ID: "r2", Kind: OperationRedo, Target: "a"})
+	v.replay(&Operation{ID: "c", Kind: OperationMerge})
+	checkOperationIDs(t, "applied", v.applied, "a", "c")
+	checkOperationIDs(t, "undone", v.undone)
+	checkOperationIDs(t, "operations", v.operations, "a", "b", "c")
+}
+
+func TestOpLogLoad(t *testing.T) {
+	dir := t.TempDir()
+	v := NewOpLog(dir)
+
+	asr := &AudioResponse{Segments: []*AudioSegment{{UUID: "s1", Text: "hello", Translated: "你好"}}}
+	before := copySegments(asr.Segments...)
+	asr.Segments[0].Translated = "您好"
+	op, err := v.Record(OperationUpdate, "user", before, copySegments(asr.Segments...))
+	if err != nil {
+		t.Fatalf("record err %+v", err)
+	}
+	if _, err := v.Undo(asr); err != nil {
+		t.Fatalf("undo err %+v", err)
+	}
+
+	// The last line truncated by crash is ignored.
+	f, err := os.OpenFile(v.filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"x","kind":"update"`)
+	f.Close()
+
+	loaded := NewOpLog(dir)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("load err %+v", err)
+	}
+	checkOperationIDs(t, "applied", loaded.applied)
+	checkOperationIDs(t, "undone", loaded.undone, op.ID)
+
+	if _, err := loaded.Redo(asr); err != nil {
+		t.Fatalf("redo err %+v", err)
+	}
+	if segment := asr.QuerySegment("s1"); segment == nil || segment.Translated != "您好" {
+		t.Fatalf("redo segment is %+v", segment)
+	}
+
+	// The operations appended after the truncated line are never lost.
+	reloaded := NewOpLog(dir)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("load err %+v", err)
+	}
+	checkOperationIDs(t, "applied", reloaded.applied, op.ID)
+	checkOperationIDs(t, "undone", reloaded.undone)
+}
+
+func TestReplaceSegmentsUpdate(t *testing.T) {
+	updated := AITime(time.Now().Add(-time.Hour))
+	asr := &AudioResponse{Segments: []*AudioSegment{
+		{UUID: "s1", Start: 0, End: 1, Text: "hello", Translated: "你好", TTS: "tts-s1.wav", Update: updated},
+		{UUID: "s2", Start: 1, End: 2, Text: "world", Translated: "世界", TTS: "tts-s2.wav", Update: updated},
+	}}
+
+	// Restore the translated text only, the update time is kept.
+	from := copySegments(asr.Segments[0])
+	to := copySegments(asr.Segments[0])
+	to[0].Translated = "您好"
+	if err := replaceSegments(asr, from, to); err != nil {
+		t.Fatalf("replace err %+v", err)
+	}
+	if segment := asr.QuerySegment("s1"); segment.Update != updated || segment.Translated != "您好" {
+		t.Errorf("segment is %+v, expect update %v", segment, updated)
+	} else if segment.TTS != "tts-s1.wav" {
+		t.Errorf("TTS %v is not kept", segment.TTS)
+	}
+
+	// Restore the text, the update time is bumped.
+	from = copySegments(asr.Segments[1])
+	to = copySegments(asr.Segments[1])
+	to[0].Text = "the world"
+	if err := replaceSegments(asr, from, to); err != nil {
+		t.Fatalf("replace err %+v", err)
+	}
+	if segment := asr.QuerySegment("s2"); segment.Update == updated {
+		t.Errorf("update of segment %v is not bumped", segment.UUID)
+	}
+
+	// Restore a removed segment, the update time is bumped, and the TTS is converted again.
+	from = copySegments(asr.Segments[1])
+	to = copySegments(asr.Segments[1])
+	to[0].UUID, to[0].Update = "s3", updated
+	if err := replaceSegments(asr, from, to); err != nil {
+		t.Fatalf("replace err %+v", err)
+	}
+	if segment := asr.QuerySegment("s3"); segment.Update == updated || segment.TTS != "" {
+		t.Errorf("restored segment is %+v", segment)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// Write the file atomically, by writing and syncing a temporary file in the same dir, then
+// renaming it to the file, so the file is either the old or the new one even if crash.
+func writeFileAtomic(filename string, b []byte, perm os.FileMode) error {
+	dir := path.Dir(filename)
+	f, err := os.CreateTemp(dir, path.Base(filename)+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "create temp of %v", filename)
+	}
+	tmpFile := f.Name()
+	defer os.Remove(tmpFile)
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "write %v", tmpFile)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "sync %v", tmpFile)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "close %v", tmpFile)
+	}
+	if err := os.Chmod(tmpFile, perm); err != nil {
+		return errors.Wrapf(err, "chmod %v", tmpFile)
+	}
+
+	if err := os.Rename(tmpFile, filename); err != nil {
+		return errors.Wrapf(err, "rename %v to %v", tmpFile, filename)
+	}
+
+	// Sync the dir, to persist the rename.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// The number of backups of file, by VODT_JSON_BACKUPS.
+func backupCount() int {
+	if v, err := strconv.Atoi(os.Getenv("VODT_JSON_BACKUPS")); err == nil && v > 0 {
+		return v
+	}
+	return 0
+}
+
+// The backup file of index, from 1 the latest to backupCount() the oldest.
+func buildBackupFile(filename string, index int) string {
+	return fmt.Sprintf("%v.%v", filename, index)
+}
+
+// Rotate the backups of file, the file.1 is the latest and the oldest is dropped, then keep the
+// current file as file.1, which is linked or copied so that the file always exists.
+func rotateBackups(filename string) error {
+	count := backupCount()
+	if count == 0 {
+		return nil
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return nil
+	}
+
+	for i := count - 1; i > 0; i-- {
+		if err := os.Rename(buildBackupFile(filename, i), buildBackupFile(filename, i+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "rotate backup %v", i)
+		}
+	}
+
+	latest := buildBackupFile(filename, 1)
+	if err := os.Remove(latest); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove %v", latest)
+	}
+	if err := os.Link(filename, latest); err == nil {
+		return nil
+	}
+	if err := copyFile(filename, latest); err != nil {
+		return errors.Wrapf(err, "backup %v", filename)
+	}
+	return nil
+}
+
+// Copy the file src to dst.
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "open %v", src)
+	}
+	defer r.Close()
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrapf(err, "create %v", dst)
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, r); err != nil {
+		return errors.Wrapf(err, "copy %v to %v", src, dst)
+	}
+	return w.Sync()
+}
+
+// Recover the file from the latest valid backup, which is loaded by load. The broken file is kept
+// as file.corrupt for investigation, and the backup is restored to the file.
+func recoverFromBackup(ctx context.Context, filename string, load func(string) error) error {
+	for i := 1; i <= backupCount(); i++ {
+		backup := buildBackupFile(filename, i)
+		if _, err := os.Stat(backup); err != nil {
+			continue
+		}
+		if err := load(backup); err != nil {
+			logger.Tf(ctx, "Ignore backup %v, err %+v", backup, err)
+			continue
+		}
+
+		if _, err := os.Stat(filename); err == nil {
+			if err := os.Rename(filename, filename+".corrupt"); err != nil {
+				return errors.Wrapf(err, "rename %v", filename)
+			}
+		}
+
+		b, err := ioutil.ReadFile(backup)
+		if err != nil {
+			return errors.Wrapf(err, "read %v", backup)
+		}
+		if err := writeFileAtomic(filename, b, os.FileMode(0644)); err != nil {
+			return errors.Wrapf(err, "restore %v", filename)
+		}
+		logger.Tf(ctx, "Recover %v from backup %v ok", filename, backup)
+		return nil
+	}
+	return errors.Errorf("no valid backup of %v", filename)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ProjectNew        = "new"
+	ProjectAsr        = "asr"
+	ProjectTranslated = "translated"
+	ProjectDubbed     = "dubbed"
+)
+
+// ProjectSummary is the metadata of project, for listing.
+type ProjectSummary struct {
+	// Project UUID
+	SID string `json:"sid"`
+	// The title of project.
+	Title string `json:"title"`
+	// The input video file URL.
+	InputURL string `json:"inputURL"`
+	// The create time.
+	Created AITime `json:"created"`
+	// The update time.
+	Updated AITime `json:"updated"`
+	// The number of segments, not removed.
+	Segments int `json:"segments"`
+	// The number of segments translated.
+	Translated int `json:"translated"`
+	// The number of segments converted to speech.
+	TTS int `json:"tts"`
+	// The pipeline status, new, asr, translated or dubbed.
+	Status string `json:"status"`
+	// Whether the project is loaded in memory.
+	Active bool `json:"active"`
+	// The kinds of running jobs.
+	Jobs []string `json:"jobs"`
+}
+
+// The main directory of project by SID.
+func buildProjectDir(sid string) string {
+	return path.Join(workDir, "projects", fmt.Sprintf("project-%v", sid))
+}
+
+// Build the summary of project.
+func buildProjectSummary(project *Project) *ProjectSummary {
+	summary := &ProjectSummary{
+		SID: project.SID, Title: project.Title, InputURL: project.InputURL,
+		Created: project.Created, Updated: AITime(project.LastModified()), Status: ProjectNew, Jobs: []string{},
+	}
+
+	if project.asrOutputObject != nil {
+		for _, segment := range project.asrOutputObject.Segments {
+			if segment.Removed {
+				continue
+			}
+			summary.Segments++
+			if segment.Translated != "" {
+				summary.Translated++
+			}
+			if segment.TTS != "" {
+				summary.TTS++
+			}
+		}
+
+		summary.Status = ProjectAsr
+		if summary.Segments > 0 && summary.Translated == summary.Segments {
+			summary.Status = ProjectTranslated
+		}
+		if summary.Segments > 0 && summary.TTS == summary.Segments {
+			summary.Status = ProjectDubbed
+		}
+	}
+
+	for _, job := range translatorServer.jobs.QueryProject(project.SID) {
+		if job.Snapshot().State == JobRunning {
+			summary.Jobs = append(summary.Jobs, job.Kind)
+		}
+	}
+	return summary
+}
+
+// List all projects, in memory or on disk under the projects directory, the latest updated first.
+func listProjects(ctx context.Context) ([]*ProjectSummary, error) {
+	var projects []*ProjectSummary
+	visited := make(map[string]bool)
+
+	translatorServer.lock.Lock()
+	stages := append([]*Project{}, translatorServer.stages...)
+	translatorServer.lock.Unlock()
+
+	for _, stage := range stages {
+		stage.lock.Lock()
+		summary := buildProjectSummary(stage)
+		stage.lock.Unlock()
+
+		summary.Active = true
+		projects = append(projects, summary)
+		visited[stage.SID] = true
+	}
+
+	projectsDir := path.Join(workDir, "projects")
+	files, err := ioutil.ReadDir(projectsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "read dir %v", projectsDir)
+	}
+
+	for _, file := range files {
+		sid := strings.TrimPrefix(file.Name(), "project-")
+		if !file.IsDir() || sid == file.Name() || visited[sid] {
+			continue
+		}
+
+		project := NewProject(func(project *Project) {
+			project.loggingCtx = ctx
+			project.SID = sid
+			project.MainDir = path.Join(projectsDir, file.Name())
+		})
+		if _, err := os.Stat(project.buildProjectFile()); err != nil {
+			continue
+		}
+		if err := project.Load(); err != nil {
+			logger.Tf(ctx, "Ignore project %v, load err %+v", sid, err)
+			continue
+		}
+		projects = append(projects, buildProjectSummary(project))
+	}
+
+	sort.Slice(projects, func(i, j int) bool {
+		return time.Time(projects[i].Updated).After(time.Time(projects[j].Updated))
+	})
+	return projects, nil
+}
+
+// Query the project in memory, or load it from disk, error if not exists.
+func queryOrLoadStage(ctx context.Context, sid string) (*Project, error) {
+	if _, err := uuid.Parse(sid); err != nil {
+		return nil, errors.Wrapf(err, "invalid sid %v", sid)
+	}
+
+	if stage := translatorServer.QueryStage(sid); stage != nil {
+		return stage, nil
+	}
+
+	filename := path.Join(buildProjectDir(sid), "project.json")
+	if _, err := os.Stat(filename); err != nil {
+		return nil, errors.Wrapf(err, "no project %v", sid)
+	}
+
+	stage := doCreateStage(ctx, sid)
+	if stage == nil {
+		return nil, errors.Errorf("load project %v", sid)
+	}
+	return stage, nil
+}
+
+// Delete the project in memory and on disk, error if any job is running. Should hold the create
+// lock, so that the project is never loaded while deleting.
+func deleteProject(ctx context.Context, sid string) error {
+	if _, err := uuid.Parse(sid); err != nil {
+		return errors.Wrapf(err, "invalid sid %v", sid)
+	}
+
+	mainDir := buildProjectDir(sid)
+	if _, err := os.Stat(mainDir); err != nil {
+		return errors.Wrapf(err, "no project %v", sid)
+	}
+
+	// Hold the lock of project, so the requests in flight fail to save after deleted, and never
+	// create the directory again.
+	stage := translatorServer.QueryStage(sid)
+	if stage != nil {
+		stage.lock.Lock()
+		defer stage.lock.Unlock()
+	}
+
+	if err := translatorServer.jobs.RemoveProject(sid); err != nil {
+		return errors.Wrapf(err, "remove jobs")
+	}
+
+	if stage != nil {
+		stage.deleted = true
+		translatorServer.RemoveStage(stage)
+	}
+	if err := os.RemoveAll(mainDir); err != nil {
+		return errors.Wrapf(err, "remove %v", mainDir)
+	}
+	return nil
+}
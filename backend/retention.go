@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse the duration of env, zero if empty or invalid, which disables the policy.
+func envDuration(key string) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Parse the size in MB of env to bytes, zero if empty or invalid, which disables the quota.
+func envQuota(key string) int64 {
+	if v, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil && v > 0 {
+		return v * 1024 * 1024
+	}
+	return 0
+}
+
+// The size in bytes of all files in dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Whether any job of project is running.
+func hasRunningJob(sid string) bool {
+	for _, job := range translatorServer.jobs.QueryProject(sid) {
+		if job.Snapshot().State == JobRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the disk quota of project and server, see VODT_PROJECT_QUOTA and VODT_SERVER_QUOTA.
+func checkDiskQuota(stage *Project) error {
+	if quota := envQuota("VODT_PROJECT_QUOTA"); quota > 0 {
+		if size := dirSize(stage.MainDir); size > quota {
+			return errors.Errorf("project %v uses %vMB, exceeds quota %vMB", stage.SID, size>>20, quota>>20)
+		}
+	}
+	if quota := envQuota("VODT_SERVER_QUOTA"); quota > 0 {
+		if size := dirSize(path.Join(workDir, "projects")); size > quota {
+			return errors.Errorf("server uses %vMB, exceeds quota %vMB", size>>20, quota>>20)
+		}
+	}
+	return nil
+}
+
+// Remove the intermediate files of project, which are never referenced by the segments or the
+// pending ASR. Never sweep the project with running job, because the files may be in use.
+func sweepProject(ctx context.Context, stage *Project) error {
+	if hasRunningJob(stage.SID) {
+		return nil
+	}
+
+	// The TTS and fitted files of segments, and of the archived segments to revert.
+	referenced := make(map[string]bool)
+	if stage.asrOutputObject != nil {
+		segments := append([]*AudioSegment{}, stage.asrOutputObject.Segments...)
+		segments = append(segments, stage.asrOutputObject.Archived...)
+		for _, segment := range segments {
+			referenced[segment.TTS], referenced[segment.Fitted] = true, true
+		}
+	}
+
+	// The ASR input is required until all chunks are done.
+	_, err := os.Stat(path.Join(stage.MainDir, "input.json"))
+	asrDone := err == nil && stage.AsrChunksDone()
+
+	// The finished jobs are never resumed, only the running jobs are kept.
+	finished := make(map[string]bool)
+	if jobs, err := loadJobs(stage.MainDir); err == nil {
+		for _, job := range jobs {
+			finished[buildJobFile(job.ID)] = job.State != JobRunning
+		}
+	}
+
+	files, err := ioutil.ReadDir(stage.MainDir)
+	if err != nil {
+		return errors.Wrapf(err, "read dir %v", stage.MainDir)
+	}
+
+	var removed int
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || referenced[name] {
+			continue
+		}
+
+		var intermediate bool
+		if strings.HasPrefix(name, "input") && path.Ext(name) == ".m4a" {
+			intermediate = asrDone
+		} else if strings.HasPrefix(name, "tts-") || strings.HasPrefix(name, "fit-") {
+			intermediate = true
+		} else if strings.HasPrefix(name, "audio-") && path.Ext(name) == ".wav" {
+			intermediate = true
+		} else if name == "separate.wav" || (strings.HasPrefix(name, "mix-") && path.Ext(name) == ".txt") {
+			intermediate = true
+		} else if finished[name] {
+			intermediate = true
+		} else if path.Ext(name) == ".tmp" {
+			// The temporary file of atomic write, left by crash.
+			intermediate = true
+		}
+		if !intermediate {
+			continue
+		}
+
+		if err := os.Remove(path.Join(stage.MainDir, name)); err != nil {
+			return errors.Wrapf(err, "remove %v", name)
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		logger.Tf(ctx, "Sweep project %v ok, removed %v files", stage.SID, removed)
+	}
+	return nil
+}
+
+// Sweep all projects on disk: remove the intermediate files of unloaded projects, delete the
+// projects not updated for VODT_DELETE_TTL, and delete the oldest unloaded projects until the
+// server is under VODT_SERVER_QUOTA.
+func sweepProjects(ctx context.Context) error {
+	projectsDir := path.Join(workDir, "projects")
+	files, err := ioutil.ReadDir(projectsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "read dir %v", projectsDir)
+	}
+
+	// The unloaded projects, which are safe to sweep or delete.
+	var projects []*Project
+	for _, file := range files {
+		sid := strings.TrimPrefix(file.Name(), "project-")
+		if !file.IsDir() || sid == file.Name() || translatorServer.IsLoaded(sid) || hasRunningJob(sid) {
+			continue
+		}
+
+		project := NewProject(func(project *Project) {
+			project.loggingCtx = ctx
+			project.SID = sid
+			project.MainDir = path.Join(projectsDir, file.Name())
+		})
+		if _, err := os.Stat(project.buildProjectFile()); err != nil {
+			continue
+		}
+		if err := project.Load(); err != nil {
+			logger.Tf(ctx, "Ignore project %v, load err %+v", sid, err)
+			continue
+		}
+		projects = append(projects, project)
+	}
+
+	// The oldest project first.
+	modified := make(map[*Project]time.Time)
+	for _, project := range projects {
+		modified[project] = project.LastModified()
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return modified[projects[i]].Before(modified[projects[j]])
+	})
+
+	ttl := envDuration("VODT_DELETE_TTL")
+	quota := envQuota("VODT_SERVER_QUOTA")
+	size := dirSize(projectsDir)
+	for _, project := range projects {
+		expired := ttl > 0 && time.Since(modified[project]) > ttl
+		overQuota := quota > 0 && size > quota
+		if !expired && !overQuota {
+			if err := sweepProject(ctx, project); err != nil {
+				logger.Tf(ctx, "Sweep project %v failed, err %+v", project.SID, err)
+			}
+			continue
+		}
+
+		projectSize := dirSize(project.MainDir)
+		if err := deleteUnloadedProject(ctx, project.SID); err != nil {
+			logger.Tf(ctx, "Delete project %v failed, err %+v", project.SID, err)
+			continue
+		}
+		size -= projectSize
+		logger.Tf(ctx, "Delete project %v ok, expired=%v, over quota=%v, update=%v, size=%vMB",
+			project.SID, expired, overQuota, modified[project].Format(time.RFC3339), projectSize>>20)
+	}
+	return nil
+}
+
+// Delete the project if it's still not loaded, with the create lock, so that the project is never
+// loaded by other request while deleting.
+func deleteUnloadedProject(ctx context.Context, sid string) error {
+	translatorServer.createLock.Lock()
+	defer translatorServer.createLock.Unlock()
+
+	if translatorServer.IsLoaded(sid) {
+		return errors.Errorf("project %v is loaded", sid)
+	}
+	return deleteProject(ctx, sid)
+}
+
+// Run the sweep every VODT_SWEEP_INTERVAL, until ctx is done.
+func runSweeper(ctx context.Context) {
+	interval := envDuration("VODT_SWEEP_INTERVAL")
+	if interval == 0 {
+		logger.Tf(ctx, "Sweeper is disabled")
+		return
+	}
+
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+			if err := sweepProjects(ctx); err != nil {
+				logger.Tf(ctx, "Sweep projects failed, err %+v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSweepProjectsByLastModified(t *testing.T) {
+	setupTestServer(t)
+	t.Setenv("VODT_DELETE_TTL", "1h")
+
+	sid := uuid.NewString()
+	importTestSubtitle(t, sid, 3)
+	stage := translatorServer.QueryStage(sid)
+	translatorServer.RemoveStage(stage)
+
+	// The project is saved long ago, but the segments are edited now.
+	stage.Updated = AITime(time.Now().Add(-2 * time.Hour))
+	b, err := json.Marshal(stage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stage.buildProjectFile(), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sweepProjects(context.Background()); err != nil {
+		t.Fatalf("sweep err %+v", err)
+	}
+	if _, err := os.Stat(stage.MainDir); err != nil {
+		t.Fatalf("project edited now is deleted, err %v", err)
+	}
+
+	// The segments are also edited long ago.
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stage.asrOutputJSON, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sweepProjects(context.Background()); err != nil {
+		t.Fatalf("sweep err %+v", err)
+	}
+	if _, err := os.Stat(stage.MainDir); !os.IsNotExist(err) {
+		t.Fatalf("project expired is not deleted, err %v", err)
+	}
+}
+
+func TestSweepProjectsNotTouchLoaded(t *testing.T) {
+	setupTestServer(t)
+	t.Setenv("VODT_UNLOAD_TTL", "72h")
+
+	sid := uuid.NewString()
+	importTestSubtitle(t, sid, 3)
+	stage := translatorServer.QueryStage(sid)
+	defer translatorServer.RemoveStage(stage)
+
+	// The loaded project is not accessed for long, which should be unloaded.
+	stage.updateLock.Lock()
+	stage.update = time.Now().Add(-100 * time.Hour)
+	stage.updateLock.Unlock()
+
+	if err := sweepProjects(context.Background()); err != nil {
+		t.Fatalf("sweep err %+v", err)
+	}
+	if !stage.Expired() {
+		t.Fatalf("project is touched by sweep")
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Locate the rune offset of each word in text, -1 if not found. The words are in the same order
+// as in the text.
+func locateWords(text string, words []AudioWord) []int {
+	runes := []rune(text)
+	offsets := make([]int, len(words))
+
+	var cursor int
+	for i, w := range words {
+		offsets[i] = -1
+		word := []rune(w.Word)
+		for pos := cursor; pos+len(word) <= len(runes) && len(word) > 0; pos++ {
+			if string(runes[pos:pos+len(word)]) == w.Word {
+				offsets[i], cursor = pos, pos+len(word)
+				break
+			}
+		}
+	}
+	return offsets
+}
+
+// Move the rune offset to the nearest space, to avoid splitting a word in half.
+func nearestSpace(runes []rune, offset int) int {
+	for delta := 0; delta < len(runes); delta++ {
+		if pos := offset - delta; pos > 0 && pos < len(runes) && unicode.IsSpace(runes[pos]) {
+			return pos
+		}
+		if pos := offset + delta; pos > 0 && pos < len(runes) && unicode.IsSpace(runes[pos]) {
+			return pos
+		}
+	}
+	return offset
+}
+
+// SplitPosition resolve the split position of segment, by the rune offset of text if offset is
+// positive, or by the time in seconds. Returns the rune offset of text, the split time and the
+// number of words in the first part, -1 if no words. Use the word-level timestamps if available,
+// or else split the time proportionally to the text.
+func (v *AudioSegment) SplitPosition(offset int, at float64) (int, float64, int, error) {
+	runes := []rune(v.Text)
+	if len(runes) == 0 || v.End <= v.Start {
+		return 0, 0, 0, errors.Errorf("invalid segment %v, text=%v, time %v~%v", v.UUID, len(runes), v.Start, v.End)
+	}
+
+	offsets := locateWords(v.Text, v.Words)
+	hasWords := len(v.Words) > 0
+	for _, o := range offsets {
+		if o < 0 {
+			hasWords = false
+		}
+	}
+
+	if offset > 0 {
+		if offset >= len(runes) {
+			return 0, 0, 0, errors.Errorf("invalid offset %v of %v", offset, len(runes))
+		}
+
+		if !hasWords {
+			at = v.Start + (v.End-v.Start)*float64(offset)/float64(len(runes))
+			return offset, at, -1, nil
+		}
+
+		// Snap the offset in a word to the nearest boundary, the start of word or the next word, so
+		// the text and the words of each part are the same.
+		for i, o := range offsets {
+			if end := o + len([]rune(v.Words[i].Word)); o < offset && offset < end {
+				if offset-o <= end-offset || i == len(offsets)-1 {
+					offset = o
+				} else {
+					offset = offsets[i+1]
+				}
+				break
+			}
+		}
+
+		// The words before offset is the first part, split at the gap of words.
+		var words int
+		for i, o := range offsets {
+			if o < offset {
+				words = i + 1
+			}
+		}
+		if words == 0 {
+			at = v.Words[0].Start
+		} else if words == len(v.Words) {
+			at = v.Words[words-1].End
+		} else {
+			at = (v.Words[words-1].End + v.Words[words].Start) / 2
+		}
+		return offset, at, words, nil
+	}
+
+	if at <= v.Start || at >= v.End {
+		return 0, 0, 0, errors.Errorf("invalid time %v of %v~%v", at, v.Start, v.End)
+	}
+
+	if !hasWords {
+		offset = int(float64(len(runes)) * (at - v.Start) / (v.End - v.Start))
+		return nearestSpace(runes, offset), at, -1, nil
+	}
+
+	// The words start before the time is the first part, split the text before next word.
+	var words int
+	for i, w := range v.Words {
+		if w.Start < at {
+			words = i + 1
+		}
+	}
+	if words == 0 {
+		offset = offsets[0]
+	} else if words == len(v.Words) {
+		offset = offsets[words-1] + len([]rune(v.Words[words-1].Word))
+	} else {
+		offset = offsets[words]
+	}
+	return offset, at, words, nil
+}
+
+// SplitSegment split the segment to two new segments at the rune offset of text or at the time,
+// see SplitPosition. The new segments have new UUIDs, without translation and TTS.
+func (v *AudioResponse) SplitSegment(segment *AudioSegment, offset int, at float64) (*AudioSegment, *AudioSegment, error) {
+	offset, at, words, err := segment.SplitPosition(offset, at)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "position")
+	}
+
+	runes := []rune(segment.Text)
+	first, second := strings.TrimSpace(string(runes[:offset])), strings.TrimSpace(string(runes[offset:]))
+	if first == "" || second == "" {
+		return nil, nil, errors.Errorf("empty text at offset %v of %v", offset, segment.Text)
+	}
+
+	// The tokens are split proportionally to the text.
+	tokens := len(segment.Tokens) * offset / len(runes)
+
+	build := func(start, end float64, text string, tokens []int, words []AudioWord) *AudioSegment {
+		return &AudioSegment{
+			ID:               segment.ID,
+			Seek:             segment.Seek,
+			Start:            start,
+			End:              end,
+			Text:             " " + text,
+			Tokens:           append([]int{}, tokens...),
+			Temperature:      segment.Temperature,
+			AvgLogprob:       segment.AvgLogprob,
+			CompressionRatio: segment.CompressionRatio,
+			NoSpeechProb:     segment.NoSpeechProb,
+			Transient:        segment.Transient,
+			Words:            append([]AudioWord{}, words...),
+			UUID:             uuid.NewString(),
+			Removed:          segment.Removed,
+			Update:           AITime(time.Now()),
+		}
+	}
+
+	var firstWords, secondWords []AudioWord
+	if words >= 0 {
+		firstWords, secondWords = segment.Words[:words], segment.Words[words:]
+	}
+	a := build(segment.Start, at, first, segment.Tokens[:tokens], firstWords)
+	b := build(at, segment.End, second, segment.Tokens[tokens:], secondWords)
+
+	for i, s := range v.Segments {
+		if s.UUID == segment.UUID {
+			segments := append([]*AudioSegment{}, v.Segments[:i]...)
+			segments = append(segments, a, b)
+			v.Segments = append(segments, v.Segments[i+1:]...)
+			return a, b, nil
+		}
+	}
+	return nil, nil, errors.Errorf("no segment %v", segment.UUID)
+}
+
+// ResegmentOptions is the options to re-flow segments to sentences.
+type ResegmentOptions struct {
+	// The max duration of sentence in seconds, no limit if zero.
+	MaxDuration float64 `json:"max_duration"`
+	// The max characters of sentence, no limit if zero.
+	MaxChars int `json:"max_chars"`
+	// The pause in seconds between words to break the sentence.
+	Pause float64 `json:"pause"`
+}
+
+// A piece of text with time, a word or a piece of sentence.
+type resegmentUnit struct {
+	text  string
+	start float64
+	end   float64
+	words []AudioWord
+}
+
+// The punctuations to end a sentence.
+const sentenceEnds = ".?!。？！…;；"
+
+// Whether the text ends a sentence, ignore the closing quotes.
+func isSentenceEnd(text string) bool {
+	runes := []rune(strings.TrimRight(strings.TrimSpace(text), "\"')]}”’」』"))
+	return len(runes) > 0 && strings.ContainsRune(sentenceEnds, runes[len(runes)-1])
+}
+
+// Whether the rune is CJK, which is not separated by space.
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// Join the texts of units, by space except for CJK.
+func joinUnits(units []*resegmentUnit) string {
+	var text string
+	for _, u := range units {
+		t := strings.TrimSpace(u.text)
+		if text != "" && t != "" {
+			last := []rune(text)[len([]rune(text))-1]
+			if !isCJK(last) || !isCJK([]rune(t)[0]) {
+				text += " "
+			}
+		}
+		text += t
+	}
+	return text
+}
+
+// Build the units of segment, the words if available, or else the pieces of sentences with the
+// time proportionally to the text. The words have no punctuation, so the text of each word is
+// taken from the text of segment, until the next word, if all words are located.
+func buildResegmentUnits(segment *AudioSegment) []*resegmentUnit {
+	if len(segment.Words) > 0 {
+		runes := []rune(segment.Text)
+		offsets := locateWords(segment.Text, segment.Words)
+
+		var units []*resegmentUnit
+		for _, w := range segment.Words {
+			units = append(units, &resegmentUnit{text: w.Word, start: w.Start, end: w.End, words: []AudioWord{w}})
+		}
+		for _, offset := range offsets {
+			if offset < 0 {
+				return units
+			}
+		}
+
+		// The text before the first word belongs to the first word.
+		for i, unit := range units {
+			from, to := offsets[i], len(runes)
+			if i == 0 {
+				from = 0
+			}
+			if i < len(offsets)-1 {
+				to = offsets[i+1]
+			}
+			unit.text = string(runes[from:to])
+		}
+		return units
+	}
+
+	runes := []rune(strings.TrimSpace(segment.Text))
+	if len(runes) == 0 {
+		return nil
+	}
+
+	// Break at the punctuation followed by space, or the CJK punctuation.
+	var units []*resegmentUnit
+	var from int
+	ratio := (segment.End - segment.Start) / float64(len(runes))
+	for i, r := range runes {
+		last := i == len(runes)-1
+		if last || (strings.ContainsRune(sentenceEnds, r) && (unicode.IsSpace(runes[i+1]) || r > unicode.MaxASCII)) {
+			units = append(units, &resegmentUnit{
+				text:  string(runes[from : i+1]),
+				start: segment.Start + ratio*float64(from),
+				end:   segment.Start + ratio*float64(i+1),
+			})
+			from = i + 1
+		}
+	}
+	return units
+}
+
+// Resegment re-flow all segments to sentence-aligned segments, by the punctuations and pauses,
+// and limited by the max duration and characters. The original segments are archived, to revert
+// by Revert. The removed segments are dropped, and the new segments have no translation and TTS.
+func (v *AudioResponse) Resegment(opts *ResegmentOptions) {
+	if v.Archived == nil {
+		v.Archived = v.Segments
+	}
+
+	var sentences [][]*resegmentUnit
+	var current []*resegmentUnit
+	flush := func() {
+		if len(current) > 0 {
+			sentences = append(sentences, current)
+			current = nil
+		}
+	}
+
+	for _, segment := range v.Segments {
+		if segment.Removed {
+			flush()
+			continue
+		}
+
+		for _, unit := range buildResegmentUnits(segment) {
+			if len(current) > 0 {
+				first, last := current[0], current[len(current)-1]
+				if opts.Pause > 0 && unit.start-last.end >= opts.Pause {
+					flush()
+				} else if opts.MaxDuration > 0 && unit.end-first.start > opts.MaxDuration {
+					flush()
+				} else if opts.MaxChars > 0 && len([]rune(joinUnits(append(current, unit)))) > opts.MaxChars {
+					flush()
+				}
+			}
+
+			current = append(current, unit)
+			if isSentenceEnd(unit.text) {
+				flush()
+			}
+		}
+	}
+	flush()
+
+	var segments []*AudioSegment
+	for index, units := range sentences {
+		segment := &AudioSegment{
+			ID:     10000 + index,
+			Start:  units[0].start,
+			End:    units[len(units)-1].end,
+			Text:   " " + joinUnits(units),
+			UUID:   uuid.NewString(),
+			Update: AITime(time.Now()),
+		}
+		for _, u := range units {
+			segment.Words = append(segment.Words, u.words...)
+		}
+		segments = append(segments, segment)
+	}
+	v.Segments = segments
+}
+
+// Revert restore the archived segments, which are archived by Resegment.
+func (v *AudioResponse) Revert() error {
+	if v.Archived == nil {
+		return errors.New("no archived segments")
+	}
+
+	v.Segments, v.Archived = v.Archived, nil
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSplitPosition(t *testing.T) {
+	segment := &AudioSegment{
+		Start: 10, End: 14, Text: " Hello world. How are you?",
+		Words: []AudioWord{
+			{Word: "Hello", Start: 10, End: 10.5}, {Word: "world", Start: 10.6, End: 11},
+			{Word: "How", Start: 12, End: 12.3}, {Word: "are", Start: 12.4, End: 12.6},
+			{Word: "you", Start: 12.7, End: 13.5},
+		},
+	}
+
+	// By offset, split at the gap of words.
+	offset, at, words, err := segment.SplitPosition(13, 0)
+	if err != nil || offset != 13 || at != 11.5 || words != 2 {
+		t.Errorf("split by offset, got %v %v %v %v", offset, at, words, err)
+	}
+
+	// By offset in a word, snap to the nearest boundary of words.
+	for _, c := range []struct {
+		offset, expect, words int
+		at                    float64
+	}{
+		{9, 7, 1, 10.55}, {10, 14, 2, 11.5}, {24, 22, 4, 12.65},
+	} {
+		offset, at, words, err = segment.SplitPosition(c.offset, 0)
+		if err != nil || offset != c.expect || math.Abs(at-c.at) > 1e-9 || words != c.words {
+			t.Errorf("split by offset %v in word, got %v %v %v %v", c.offset, offset, at, words, err)
+		}
+	}
+
+	// By time, split the text before the next word.
+	offset, at, words, err = segment.SplitPosition(0, 11.8)
+	if err != nil || offset != 14 || at != 11.8 || words != 2 {
+		t.Errorf("split by time, got %v %v %v %v", offset, at, words, err)
+	}
+
+	// Without words, split proportionally to the text, at the nearest space.
+	segment.Words = nil
+	offset, at, words, err = segment.SplitPosition(0, 12)
+	if err != nil || offset != 13 || at != 12 || words != -1 {
+		t.Errorf("split by time without words, got %v %v %v %v", offset, at, words, err)
+	}
+
+	for _, c := range [][2]float64{{100, 0}, {0, 10}, {0, 14}} {
+		if _, _, _, err := segment.SplitPosition(int(c[0]), c[1]); err == nil {
+			t.Errorf("split at %v should fail", c)
+		}
+	}
+}
+
+func TestResegment(t *testing.T) {
+	asr := &AudioResponse{Segments: []*AudioSegment{
+		{Start: 0, End: 3, Text: " Hello world. How are", Words: []AudioWord{
+			{Word: "Hello", Start: 0, End: 0.5}, {Word: "world", Start: 0.6, End: 1},
+			{Word: "How", Start: 2, End: 2.3}, {Word: "are", Start: 2.4, End: 3},
+		}},
+		{Start: 3, End: 6, Text: " you? Fine, thanks.", Words: []AudioWord{
+			{Word: "you", Start: 3, End: 3.5}, {Word: "Fine", Start: 4, End: 4.5}, {Word: "thanks", Start: 4.6, End: 6},
+		}},
+		{Start: 6, End: 8, Text: "你好。世界。"},
+	}}
+
+	// The punctuations are taken from the text, because the words have no punctuation.
+	asr.Resegment(&ResegmentOptions{})
+	expects := []struct {
+		text       string
+		start, end float64
+		words      int
+	}{
+		{" Hello world.", 0, 1, 2}, {" How are you?", 2, 3.5, 3}, {" Fine, thanks.", 4, 6, 2},
+		{" 你好。", 6, 7, 0}, {" 世界。", 7, 8, 0},
+	}
+	if len(asr.Segments) != len(expects) {
+		t.Fatalf("resegment to %v segments, expect %v", len(asr.Segments), len(expects))
+	}
+	for i, expect := range expects {
+		if s := asr.Segments[i]; s.Text != expect.text || s.Start != expect.start || s.End != expect.end || len(s.Words) != expect.words {
+			t.Errorf("segment %v is %q %v~%v words=%v, expect %+v", i, s.Text, s.Start, s.End, len(s.Words), expect)
+		}
+	}
+
+	// Split the sentence by the max characters.
+	if err := asr.Revert(); err != nil {
+		t.Fatalf("revert err %+v", err)
+	}
+	asr.Resegment(&ResegmentOptions{MaxChars: 8})
+	if s := asr.Segments[0]; s.Text != " Hello" {
+		t.Errorf("segment is %q, expect Hello", s.Text)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// Build the arguments of command template, replace the {input}, {output} and {name} variables.
+// The template is split by spaces, without shell, so the variables are never interpreted.
+func buildSeparateArgs(template, input, output string) []string {
+	name := strings.TrimSuffix(path.Base(input), path.Ext(input))
+	replacer := strings.NewReplacer("{input}", input, "{output}", output, "{name}", name)
+
+	var args []string
+	for _, arg := range strings.Fields(template) {
+		args = append(args, replacer.Replace(arg))
+	}
+	return args
+}
+
+// Separate the vocals from the accompaniment of input, by the external command VODT_SEPARATE_CMD,
+// for example, demucs or spleeter. The accompaniment stem VODT_SEPARATE_STEM is saved in project,
+// which is the bed under the dub when export with mix.
+func doSeparate(ctx context.Context, stage *Project, job *Job) error {
+	if strings.TrimSpace(os.Getenv("VODT_SEPARATE_CMD")) == "" {
+		return errors.New("VODT_SEPARATE_CMD is required")
+	}
+
+	inputFile, err := resolveInputFile(stage.InputURL)
+	if err != nil {
+		return errors.Wrapf(err, "input")
+	}
+
+	// Extract the audio of input, because some tools only accept audio file.
+	separateInput := path.Join(stage.MainDir, "separate.wav")
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-i", inputFile,
+		"-vn", "-c:a", "pcm_s16le", "-ac", "2", "-ar", "44100",
+		"-y", separateInput,
+	)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "exec %v, output is %v", cmd.String(), string(b))
+	}
+	job.SetPercent(10)
+	job.Logf(ctx, "Extract audio %v ok", separateInput)
+
+	separateOutput := path.Join(stage.MainDir, "separate")
+	if err := os.MkdirAll(separateOutput, os.ModeDir|os.FileMode(0755)); err != nil {
+		return errors.Wrapf(err, "create dir %v", separateOutput)
+	}
+	defer os.RemoveAll(separateOutput)
+
+	args := buildSeparateArgs(os.Getenv("VODT_SEPARATE_CMD"), separateInput, separateOutput)
+	if len(args) == 0 {
+		return errors.Errorf("invalid cmd %v", os.Getenv("VODT_SEPARATE_CMD"))
+	}
+	cmd = exec.CommandContext(ctx, args[0], args[1:]...)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "exec %v, output is %v", cmd.String(), string(b))
+	}
+	job.SetPercent(90)
+	job.Logf(ctx, "Separate %v ok, cmd is %v", separateInput, cmd.String())
+
+	stem := buildSeparateArgs(os.Getenv("VODT_SEPARATE_STEM"), separateInput, separateOutput)
+	if len(stem) != 1 {
+		return errors.Errorf("invalid stem %v", os.Getenv("VODT_SEPARATE_STEM"))
+	}
+	stemFile := path.Join(separateOutput, stem[0])
+	if _, err := os.Stat(stemFile); err != nil {
+		return errors.Wrapf(err, "no stem %v", stemFile)
+	}
+
+	accompaniment := "accompaniment" + path.Ext(stemFile)
+	if err := os.Rename(stemFile, path.Join(stage.MainDir, accompaniment)); err != nil {
+		return errors.Wrapf(err, "rename %v", stemFile)
+	}
+	os.Remove(separateInput)
+
+	stage.lock.Lock()
+	defer stage.lock.Unlock()
+
+	stage.Accompaniment = accompaniment
+	if err := stage.Save(); err != nil {
+		return errors.Wrapf(err, "save project")
+	}
+	job.SetResult(accompaniment)
+	job.Logf(ctx, "Save accompaniment %v ok", accompaniment)
+	return nil
+}
+
+// Query the accompaniment file of project, empty if not separated.
+func queryAccompaniment(stage *Project) string {
+	if stage.Accompaniment == "" {
+		return ""
+	}
+
+	accompanimentFile := path.Join(stage.MainDir, stage.Accompaniment)
+	if _, err := os.Stat(accompanimentFile); err != nil {
+		return ""
+	}
+	return accompanimentFile
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TranslateAttempt is an attempt to translate the segment to fit the slot, see doFitTranslate.
+type TranslateAttempt struct {
+	// The translated text.
+	Translated string `json:"translated"`
+	// The estimated speaking duration in seconds.
+	Estimated float64 `json:"estimated"`
+	// The measured TTS duration in seconds, zero if not measured.
+	Measured float64 `json:"measured"`
+	// The budget of speaking duration in seconds.
+	Budget float64 `json:"budget"`
+	// Whether the text fits the budget.
+	Fit bool `json:"fit"`
+	// The engine which produced the text.
+	By string `json:"by"`
+	// Create time.
+	Created AITime `json:"created"`
+}
+
+// FitTranslateOptions is the options to translate the segment to fit the slot.
+type FitTranslateOptions struct {
+	// The max number of attempts, to translate then shorten.
+	Attempts int `json:"attempts"`
+	// Whether measure the duration by TTS, or else estimate by VODT_SPEAKING_RATE.
+	Measure bool `json:"measure"`
+}
+
+// Estimate the speaking duration in seconds of text, by the speaking rate VODT_SPEAKING_RATE in
+// syllables per second. Each CJK rune is a syllable, and each other word is about 1.5 syllables.
+func estimateSpeakingDuration(text string) float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("VODT_SPEAKING_RATE"), 64)
+	if err != nil || rate <= 0 {
+		rate = 4.5
+	}
+
+	var syllables float64
+	var inWord bool
+	for _, r := range text {
+		if isCJK(r) {
+			syllables, inWord = syllables+1, false
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if !inWord {
+				syllables += 1.5
+			}
+			inWord = true
+		} else {
+			inWord = false
+		}
+	}
+	return syllables / rate
+}
+
+// The budget of speaking duration in seconds of segment, which is the slot, stretched by the
+// max tempo of fitting.
+func speakingBudget(target *AudioSegment) float64 {
+	budget := target.End - target.Start
+	if _, maxTempo, err := fitTempoBounds(); err == nil {
+		budget *= maxTempo
+	}
+	return budget
+}
+
+// The max characters of shorter text, to make the text of duration fit the budget.
+func shorterLimit(text string, duration, budget float64) int {
+	ratio := 1.0
+	if duration > budget && duration > 0 {
+		ratio = budget / duration
+	}
+
+	// Always shorter than the text, because the estimation is not accurate.
+	limit := int(float64(len([]rune(strings.TrimSpace(text)))) * ratio * 0.9)
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// Make the translated text shorter by VODT_SHORTER_MODEL, limited to the max characters if
+// positive, with the translated text of previous segment as context.
+func doShorter(ctx context.Context, previous, text string, limit int) (string, error) {
+	if os.Getenv("OPENAI_API_KEY") == "" {
+		return "", errors.New("OPENAI_API_KEY is required")
+	}
+
+	prompt := os.Getenv("VODT_SHORTER_PROMPT")
+	if limit > 0 {
+		prompt = fmt.Sprintf("%v The text must be no more than %v characters.", prompt, limit)
+	}
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: prompt},
+	}
+	if previous != "" {
+		messages = append(messages, []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: previous},
+			{Role: openai.ChatMessageRoleAssistant, Content: previous},
+		}...)
+	}
+	messages = append(messages, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: text},
+	}...)
+
+	client := openai.NewClientWithConfig(aiConfig)
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:    os.Getenv("VODT_SHORTER_MODEL"),
+		Messages: messages,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "shorter")
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.Errorf("no choices")
+	}
+
+	logger.Tf(ctx, "Shorter ok, messages=%v, limit=%v, resp is <%v>B", len(messages), limit, len(resp.Choices[0].Message.Content))
+	return resp.Choices[0].Message.Content, nil
+}
+
+// Translate the segment to fit the slot, by translate, then estimate or measure the duration, then
+// shorten the text, until the text fits the budget or the attempts is used up. The attempts are
+// recorded in the segment, and the last text is used. The target should be a copy, because it's
+// called without lock. If measure, the TTS of target is a temporary file, see acceptAttemptTTS.
+func doFitTranslate(ctx context.Context, stage *Project, engine string, target *AudioSegment, req *TranslateRequest, opts *FitTranslateOptions) error {
+	translator, err := NewTranslator(engine)
+	if err != nil {
+		return errors.Wrapf(err, "translator %v", engine)
+	}
+
+	translated, err := translator.Translate(ctx, req)
+	if err != nil {
+		return errors.Wrapf(err, "translate")
+	}
+
+	by, budget := engine, speakingBudget(target)
+	target.Attempts = nil
+	for i := 0; ; i++ {
+		target.Translated, target.TranslatedAt, target.TranslatedBy = translated, AITime(time.Now()), by
+
+		attempt := TranslateAttempt{
+			Translated: translated, Estimated: estimateSpeakingDuration(translated), Budget: budget,
+			By: by, Created: AITime(time.Now()),
+		}
+
+		duration := attempt.Estimated
+		if opts.Measure {
+			if err := doAttemptTTS(ctx, stage, target); err != nil {
+				return errors.Wrapf(err, "tts")
+			}
+			attempt.Measured, duration = target.TTSDuration, target.TTSDuration
+		}
+
+		attempt.Fit = duration <= budget
+		target.Attempts = append(target.Attempts, attempt)
+		logger.Tf(ctx, "Fit translate %v attempt %v/%v, by=%v, duration=%.3f, budget=%.3f, fit=%v",
+			target.UUID, i+1, opts.Attempts, by, duration, budget, attempt.Fit)
+
+		if attempt.Fit || i+1 >= opts.Attempts {
+			break
+		}
+
+		limit := shorterLimit(translated, duration, budget)
+		if translated, err = doShorter(ctx, req.PreviousTranslated, translated, limit); err != nil {
+			if opts.Measure {
+				os.Remove(path.Join(stage.MainDir, target.TTS))
+			}
+			return errors.Wrapf(err, "shorter")
+		}
+		by = fmt.Sprintf("%v+shorter", engine)
+	}
+
+	return nil
+}
+
+// Convert the translated text of attempt to a temporary TTS file, to never change the TTS file of
+// segment before the attempt is accepted, see acceptAttemptTTS. The file is removed if failed.
+func doAttemptTTS(ctx context.Context, stage *Project, target *AudioSegment) error {
+	ttsFilename, name, err := synthesizeTTS(ctx, stage, target.Translated, fmt.Sprintf("tts-%v-attempt", target.UUID))
+	if err != nil {
+		return err
+	}
+
+	target.TTS, target.TTSAt, target.TTSProvider = ttsFilename, AITime(time.Now()), name
+	if err := detectTTS(ctx, stage, target); err != nil {
+		os.Remove(path.Join(stage.MainDir, ttsFilename))
+		return errors.Wrapf(err, "detect")
+	}
+	return nil
+}
+
+// Accept the TTS of attempt, rename the temporary file to the TTS file of segment.
+func acceptAttemptTTS(stage *Project, target *AudioSegment) error {
+	ttsFilename := fmt.Sprintf("tts-%v%v", target.UUID, path.Ext(target.TTS))
+	if err := os.Rename(path.Join(stage.MainDir, target.TTS), path.Join(stage.MainDir, ttsFilename)); err != nil {
+		return errors.Wrapf(err, "rename %v to %v", target.TTS, ttsFilename)
+	}
+	target.TTS = ttsFilename
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAttemptTTS(t *testing.T) {
+	setupTestServer(t)
+	stage := &Project{SID: uuid.NewString(), MainDir: t.TempDir()}
+
+	// The TTS file of segment, which is never changed by attempts.
+	target := &AudioSegment{UUID: uuid.NewString(), Translated: "你好", TTSDuration: 1}
+	target.TTS = "tts-" + target.UUID + ".wav"
+	if err := os.WriteFile(path.Join(stage.MainDir, target.TTS), []byte("live"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	attempt := *target
+	if err := doAttemptTTS(context.Background(), stage, &attempt); err != nil {
+		// Without ffprobe, the attempt fails and the temporary file is removed.
+		if _, err := os.Stat(path.Join(stage.MainDir, attempt.TTS)); !os.IsNotExist(err) {
+			t.Errorf("temporary file %v is not removed, err %v", attempt.TTS, err)
+		}
+	} else {
+		if attempt.TTS == target.TTS {
+			t.Fatalf("attempt writes to the TTS file %v", target.TTS)
+		}
+		if err := acceptAttemptTTS(stage, &attempt); err != nil {
+			t.Fatalf("accept err %+v", err)
+		}
+		if attempt.TTS != target.TTS {
+			t.Errorf("accept to %v, expect %v", attempt.TTS, target.TTS)
+		}
+	}
+
+	if b, err := os.ReadFile(path.Join(stage.MainDir, target.TTS)); err != nil {
+		t.Fatal(err)
+	} else if attempt.TTS != target.TTS && string(b) != "live" {
+		t.Errorf("TTS file is changed by the failed attempt")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SubtitleSRT = "srt"
+	SubtitleVTT = "vtt"
+)
+
+const (
+	SubtitleSource     = "source"
+	SubtitleTranslated = "translated"
+	SubtitleBilingual  = "bilingual"
+)
+
+// Format the time in seconds to subtitle time, like 00:01:02,345 for SRT, or 00:01:02.345 for VTT.
+func formatSubtitleTime(seconds float64, format string) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	ms := int64(seconds*1000 + 0.5)
+	separator := ","
+	if format == SubtitleVTT {
+		separator = "."
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%v%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
+
+// The lines of subtitle of segment by mode, empty if no text.
+func subtitleLines(segment *AudioSegment, mode string) []string {
+	var lines []string
+	source, translated := strings.TrimSpace(segment.Text), strings.TrimSpace(segment.Translated)
+	if (mode == SubtitleSource || mode == SubtitleBilingual) && source != "" {
+		lines = append(lines, source)
+	}
+	if (mode == SubtitleTranslated || mode == SubtitleBilingual) && translated != "" {
+		lines = append(lines, translated)
+	}
+	return lines
+}
+
+// Subtitle generate the subtitle of segments in format SRT or VTT, with the source text, the
+// translated text or both by mode. The removed segments and segments without text are ignored.
+func (v *AudioResponse) Subtitle(format, mode string) (string, error) {
+	if format != SubtitleSRT && format != SubtitleVTT {
+		return "", errors.Errorf("invalid format %v", format)
+	}
+	if mode != SubtitleSource && mode != SubtitleTranslated && mode != SubtitleBilingual {
+		return "", errors.Errorf("invalid mode %v", mode)
+	}
+
+	var sb strings.Builder
+	if format == SubtitleVTT {
+		sb.WriteString("WEBVTT\n\n")
+	}
+
+	var index int
+	for _, segment := range v.Segments {
+		if segment.Removed || segment.End <= segment.Start {
+			continue
+		}
+
+		lines := subtitleLines(segment, mode)
+		if len(lines) == 0 {
+			continue
+		}
+
+		index++
+		sb.WriteString(fmt.Sprintf("%v\n", index))
+		sb.WriteString(fmt.Sprintf("%v --> %v\n",
+			formatSubtitleTime(segment.Start, format), formatSubtitleTime(segment.End, format),
+		))
+		for _, line := range lines {
+			// The empty line ends the cue, so never write empty line in text.
+			for _, l := range strings.Split(line, "\n") {
+				if l = strings.TrimSpace(l); l != "" {
+					sb.WriteString(l + "\n")
+				}
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// Parse the subtitle time like 00:01:02,345 of SRT, or 01:02.345 of VTT, to seconds.
+func parseSubtitleTime(s string) (float64, error) {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(s), ",", "."), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, errors.Errorf("invalid time %v", s)
+	}
+
+	var seconds float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, errors.Errorf("invalid time %v", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+// The tags in VTT cue text, for example, <i>, <c.yellow> or <00:00:01.000>.
+var subtitleTags = regexp.MustCompile(`<[^>]*>`)
+
+// ParseSubtitle parse the SRT or VTT subtitle to ASR object, each cue is a segment with UUID. The
+// VTT header, notes, styles and the cue settings are ignored.
+func ParseSubtitle(content string) (*AudioResponse, error) {
+	content = strings.TrimPrefix(content, "\ufeff")
+	content = strings.ReplaceAll(strings.ReplaceAll(content, "\r\n", "\n"), "\r", "\n")
+
+	resp := &AudioResponse{Task: "import"}
+	var texts []string
+	for _, block := range strings.Split(content, "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+
+		// Find the timing line, the lines before it are the identifier, ignore the block without it.
+		timing := -1
+		for i, line := range lines {
+			if strings.Contains(line, "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 {
+			continue
+		}
+
+		times := strings.SplitN(lines[timing], "-->", 2)
+		if len(strings.Fields(times[1])) == 0 {
+			return nil, errors.Errorf("no end time %v", lines[timing])
+		}
+		start, err := parseSubtitleTime(times[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse start of %v", lines[timing])
+		}
+		end, err := parseSubtitleTime(strings.Fields(times[1])[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse end of %v", lines[timing])
+		}
+		if end <= start {
+			return nil, errors.Errorf("invalid time %v", lines[timing])
+		}
+
+		var text []string
+		for _, line := range lines[timing+1:] {
+			if line = strings.TrimSpace(subtitleTags.ReplaceAllString(line, "")); line != "" {
+				text = append(text, line)
+			}
+		}
+		if len(text) == 0 {
+			continue
+		}
+
+		resp.Segments = append(resp.Segments, &AudioSegment{
+			ID:     10000 + len(resp.Segments),
+			Start:  start,
+			End:    end,
+			Text:   " " + strings.Join(text, " "),
+			UUID:   uuid.NewString(),
+			Update: AITime(time.Now()),
+		})
+		texts = append(texts, strings.Join(text, " "))
+		if end > resp.Duration {
+			resp.Duration = end
+		}
+	}
+
+	if len(resp.Segments) == 0 {
+		return nil, errors.New("no cues in subtitle")
+	}
+	resp.Text = strings.Join(texts, " ")
+	return resp, nil
+}
+
+// SubtitleStyle is the style of burned subtitle, saved in project.
+type SubtitleStyle struct {
+	// The font name, for example, Noto Sans CJK SC.
+	Font string `json:"font"`
+	// The font size, in pixels of 1080p video.
+	Size int `json:"size"`
+	// The position of subtitle, bottom, middle or top.
+	Position string `json:"position"`
+	// The text color, like #FFFFFF.
+	Color string `json:"color"`
+	// The outline color, like #000000.
+	OutlineColor string `json:"outline_color"`
+	// The vertical margin, in pixels of 1080p video.
+	Margin int `json:"margin"`
+}
+
+func NewSubtitleStyle() *SubtitleStyle {
+	return &SubtitleStyle{
+		Font: "Noto Sans CJK SC", Size: 48, Position: "bottom", Color: "#FFFFFF", OutlineColor: "#000000",
+		Margin: 40,
+	}
+}
+
+func (v *SubtitleStyle) Validate() error {
+	if v.Font == "" || strings.ContainsAny(v.Font, ",\n") {
+		return errors.Errorf("invalid font %v", v.Font)
+	}
+	if v.Size <= 0 || v.Margin < 0 {
+		return errors.Errorf("invalid size %v or margin %v", v.Size, v.Margin)
+	}
+	if v.Position != "bottom" && v.Position != "middle" && v.Position != "top" {
+		return errors.Errorf("invalid position %v", v.Position)
+	}
+	if _, err := assColor(v.Color); err != nil {
+		return errors.Wrapf(err, "color")
+	}
+	if _, err := assColor(v.OutlineColor); err != nil {
+		return errors.Wrapf(err, "outline color")
+	}
+	return nil
+}
+
+// Convert the color like #RRGGBB to ASS color like &H00BBGGRR.
+func assColor(color string) (string, error) {
+	if len(color) != 7 || color[0] != '#' {
+		return "", errors.Errorf("invalid color %v", color)
+	}
+	if _, err := strconv.ParseUint(color[1:], 16, 32); err != nil {
+		return "", errors.Wrapf(err, "invalid color %v", color)
+	}
+	return strings.ToUpper(fmt.Sprintf("&H00%v%v%v", color[5:7], color[3:5], color[1:3])), nil
+}
+
+// Format the time in seconds to ASS time, like 0:01:02.34.
+func formatASSTime(seconds float64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	cs := int64(seconds*100 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// ASS generate the ASS subtitle of segments by mode and style, to burn into the video.
+func (v *AudioResponse) ASS(mode string, style *SubtitleStyle) (string, error) {
+	if mode != SubtitleSource && mode != SubtitleTranslated && mode != SubtitleBilingual {
+		return "", errors.Errorf("invalid mode %v", mode)
+	}
+	if err := style.Validate(); err != nil {
+		return "", errors.Wrapf(err, "style")
+	}
+
+	// The numpad alignment of ASS, 2 is bottom center.
+	alignment := map[string]int{"bottom": 2, "middle": 5, "top": 8}[style.Position]
+	color, _ := assColor(style.Color)
+	outlineColor, _ := assColor(style.OutlineColor)
+
+	var sb strings.Builder
+	sb.WriteString("[Script Info]\nScriptType: v4.00+\nPlayResX: 1920\nPlayResY: 1080\nWrapStyle: 0\n\n")
+	sb.WriteString("[V4+ Styles]\n")
+	sb.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, " +
+		"Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, " +
+		"Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	sb.WriteString(fmt.Sprintf("Style: Default,%v,%v,%v,%v,%v,&H80000000,0,0,0,0,100,100,0,0,1,2,0,%v,40,40,%v,1\n\n",
+		style.Font, style.Size, color, color, outlineColor, alignment, style.Margin,
+	))
+	sb.WriteString("[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+
+	for _, segment := range v.Segments {
+		if segment.Removed || segment.End <= segment.Start {
+			continue
+		}
+
+		lines := subtitleLines(segment, mode)
+		if len(lines) == 0 {
+			continue
+		}
+
+		// The braces are override tags, and the newline is \N in ASS.
+		text := strings.Join(lines, "\n")
+		text = strings.NewReplacer("{", "(", "}", ")", "\n", "\\N").Replace(text)
+		sb.WriteString(fmt.Sprintf("Dialogue: 0,%v,%v,Default,,0,0,0,,%v\n",
+			formatASSTime(segment.Start), formatASSTime(segment.End), text,
+		))
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSubtitle(t *testing.T) {
+	srt := string(rune(0xfeff)) + "1\r\n00:00:01,000 --> 00:00:02,500\r\nHello\r\nworld\r\n\r\n" +
+		"2\r\n00:00:03,000 --> 00:00:04,000\r\n<i>Bye</i>\r\n"
+	vtt := "WEBVTT\n\nNOTE comment\n\nintro\n00:01.000 --> 00:02.500 align:start\nHello\nworld\n\n" +
+		"00:00:03.000 --> 00:00:04.000\n<c.yellow>Bye</c>\n"
+
+	for _, content := range []string{srt, vtt} {
+		asr, err := ParseSubtitle(content)
+		if err != nil {
+			t.Fatalf("parse err %+v", err)
+		}
+		if len(asr.Segments) != 2 || asr.Duration != 4 || asr.Text != "Hello world Bye" {
+			t.Fatalf("parse %v segments, duration=%v, text=%v", len(asr.Segments), asr.Duration, asr.Text)
+		}
+		if s := asr.Segments[0]; s.Start != 1 || s.End != 2.5 || strings.TrimSpace(s.Text) != "Hello world" || s.UUID == "" {
+			t.Errorf("invalid first segment %+v", s)
+		}
+		if s := asr.Segments[1]; strings.TrimSpace(s.Text) != "Bye" {
+			t.Errorf("invalid second segment %+v", s)
+		}
+	}
+
+	for _, content := range []string{
+		"", "1\n00:00:01,000 --> \nHello\n", "1\n00:00:02,000 --> 00:00:01,000\nHello\n", "1\nabc --> 00:00:01,000\nHello\n",
+	} {
+		if _, err := ParseSubtitle(content); err == nil {
+			t.Errorf("parse %q should fail", content)
+		}
+	}
+}
+
+func TestSubtitleRoundTrip(t *testing.T) {
+	asr := &AudioResponse{Segments: []*AudioSegment{
+		{Start: 1, End: 2.5, Text: " Hello", Translated: "你好"},
+		{Start: 3, End: 4, Text: " Removed", Removed: true},
+		{Start: 5, End: 6.25, Text: " World", Translated: "世界"},
+	}}
+
+	for _, format := range []string{SubtitleSRT, SubtitleVTT} {
+		content, err := asr.Subtitle(format, SubtitleTranslated)
+		if err != nil {
+			t.Fatalf("subtitle err %+v", err)
+		}
+
+		parsed, err := ParseSubtitle(content)
+		if err != nil {
+			t.Fatalf("parse err %+v", err)
+		}
+		if len(parsed.Segments) != 2 || parsed.Segments[1].Start != 5 || parsed.Segments[1].End != 6.25 ||
+			strings.TrimSpace(parsed.Segments[1].Text) != "世界" {
+			t.Errorf("invalid round trip of %v: %v", format, content)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TranslateRequest is the text to translate, with the previous segment as context.
+type TranslateRequest struct {
+	// The text to translate.
+	Text string
+	// The previous source text, optional.
+	PreviousText string
+	// The previous translated text, optional.
+	PreviousTranslated string
+}
+
+// Whether the segment should be translated, if not translated or updated after translated.
+func shouldTranslate(target *AudioSegment) bool {
+	if target.Removed || target.Text == "" {
+		return false
+	}
+	return target.Translated == "" || time.Time(target.Update).After(time.Time(target.TranslatedAt))
+}
+
+// Build the translate request of segment, with the previous segment as context.
+func buildTranslateRequest(stage *Project, target *AudioSegment) *TranslateRequest {
+	req := &TranslateRequest{Text: target.Text}
+	if previous := stage.asrOutputObject.QueryPrevious(target); previous != nil {
+		req.PreviousText, req.PreviousTranslated = previous.Text, previous.Translated
+	}
+	return req
+}
+
+// Translator translates the text of segment to the target language.
+type Translator interface {
+	Translate(ctx context.Context, req *TranslateRequest) (string, error)
+}
+
+// NewTranslator create the translate engine by name, see VODT_TRANSLATOR.
+func NewTranslator(name string) (Translator, error) {
+	switch name {
+	case "openai":
+		if os.Getenv("OPENAI_API_KEY") == "" {
+			return nil, errors.New("OPENAI_API_KEY is required")
+		}
+		return &chatTranslator{config: aiConfig, model: os.Getenv("VODT_CHAT_MODEL")}, nil
+	case "openai-compatible":
+		if os.Getenv("VODT_TRANSLATE_BASE_URL") == "" {
+			return nil, errors.New("VODT_TRANSLATE_BASE_URL is required")
+		}
+		config := openai.DefaultConfig(os.Getenv("VODT_TRANSLATE_KEY"))
+		config.BaseURL = os.Getenv("VODT_TRANSLATE_BASE_URL")
+		return &chatTranslator{config: config, model: os.Getenv("VODT_TRANSLATE_MODEL")}, nil
+	case "deepl":
+		if os.Getenv("VODT_DEEPL_KEY") == "" {
+			return nil, errors.New("VODT_DEEPL_KEY is required")
+		}
+		return &deeplTranslator{
+			url: os.Getenv("VODT_DEEPL_URL"), key: os.Getenv("VODT_DEEPL_KEY"),
+			target: os.Getenv("VODT_DEEPL_TARGET_LANG"),
+		}, nil
+	case "dict":
+		dict := &dictTranslator{words: make(map[string]string)}
+		if err := dict.Load(os.Getenv("VODT_DICT_FILE")); err != nil {
+			return nil, errors.Wrapf(err, "load dict")
+		}
+		return dict, nil
+	default:
+		return nil, errors.Errorf("Unknown translator %v", name)
+	}
+}
+
+// The translator by chat completion, for OpenAI or any OpenAI-compatible server, for example,
+// ollama or vLLM.
+type chatTranslator struct {
+	config openai.ClientConfig
+	model  string
+}
+
+func (v *chatTranslator) Translate(ctx context.Context, req *TranslateRequest) (string, error) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: os.Getenv("VODT_CHAT_PROMPT")},
+	}
+	if req.PreviousText != "" && req.PreviousTranslated != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleUser, Content: req.PreviousText,
+		})
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role: openai.ChatMessageRoleAssistant, Content: req.PreviousTranslated,
+		})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser, Content: req.Text,
+	})
+
+	client := openai.NewClientWithConfig(v.config)
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:    v.model,
+		Messages: messages,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "chat completion")
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.Errorf("no choices")
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// The translator by DeepL REST API, see https://developers.deepl.com/docs/api-reference/translate
+type deeplTranslator struct {
+	url    string
+	key    string
+	target string
+}
+
+func (v *deeplTranslator) Translate(ctx context.Context, req *TranslateRequest) (string, error) {
+	data := map[string]interface{}{
+		"text":        []string{req.Text},
+		"target_lang": v.target,
+	}
+	if req.PreviousText != "" {
+		data["context"] = req.PreviousText
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", errors.Wrapf(err, "marshal")
+	}
+
+	r, err := http.NewRequestWithContext(ctx, "POST", v.url, bytes.NewReader(b))
+	if err != nil {
+		return "", errors.Wrapf(err, "create request %v", v.url)
+	}
+	r.Header.Set("Authorization", "DeepL-Auth-Key "+v.key)
+	r.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return "", errors.Wrapf(err, "request %v", v.url)
+	}
+	defer res.Body.Close()
+
+	if b, err = ioutil.ReadAll(res.Body); err != nil {
+		return "", errors.Wrapf(err, "read body")
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("request %v, status=%v, body is %v", v.url, res.StatusCode, string(b))
+	}
+
+	var resp struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", errors.Wrapf(err, "unmarshal %v", string(b))
+	}
+	if len(resp.Translations) == 0 {
+		return "", errors.Errorf("no translations in %v", string(b))
+	}
+
+	return resp.Translations[0].Text, nil
+}
+
+// The offline translator by dictionary, which maps the whole text or each word to the target
+// language, mostly for tests.
+type dictTranslator struct {
+	// The lower case source text or word to the translated text.
+	words map[string]string
+}
+
+func (v *dictTranslator) Load(filename string) error {
+	if filename == "" {
+		return nil
+	}
+
+	var words map[string]string
+	if b, err := ioutil.ReadFile(filename); err != nil {
+		return errors.Wrapf(err, "read json file %v", filename)
+	} else if err = json.Unmarshal(b, &words); err != nil {
+		return errors.Wrapf(err, "unmarshal json file %v", filename)
+	}
+
+	for k, w := range words {
+		v.words[strings.ToLower(strings.TrimSpace(k))] = w
+	}
+	return nil
+}
+
+func (v *dictTranslator) Translate(ctx context.Context, req *TranslateRequest) (string, error) {
+	text := strings.TrimSpace(req.Text)
+	if w, ok := v.words[strings.ToLower(text)]; ok {
+		return w, nil
+	}
+
+	// Translate word by word, keep the unknown words.
+	var translated []string
+	for _, word := range strings.Fields(text) {
+		key := strings.ToLower(strings.Trim(word, ".,!?;:\"'"))
+		if w, ok := v.words[key]; ok {
+			translated = append(translated, w)
+		} else {
+			translated = append(translated, word)
+		}
+	}
+	return strings.Join(translated, " "), nil
+}
+
+// TranslateAllOptions is the options to translate all segments of project.
+type TranslateAllOptions struct {
+	// The number of segments to translate in parallel.
+	Concurrency int `json:"concurrency"`
+	// The number of retries for each segment.
+	Retry int `json:"retry"`
+}
+
+// Translate all segments which should be translated, in a pool of workers. The segments are
+// started in time order, so the previous segment is used as context if it's translated. Each
+// segment is saved once translated, so the job can be restarted to continue.
+func doTranslateAll(ctx context.Context, stage *Project, job *Job, segments []*AudioSegment, opts *TranslateAllOptions) error {
+	engine := stage.TranslatorName()
+	translator, err := NewTranslator(engine)
+	if err != nil {
+		return errors.Wrapf(err, "translator %v", engine)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make(chan bool, opts.Concurrency)
+	for _, segment := range segments {
+		wg.Add(1)
+		tokens <- true
+		go func(target *AudioSegment) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+
+			var translated string
+			var err error
+			for i := 0; i <= opts.Retry && ctx.Err() == nil; i++ {
+				if i > 0 {
+					logger.Tf(ctx, "Translate %v retry %v/%v, err %+v", target.UUID, i, opts.Retry, err)
+					select {
+					case <-ctx.Done():
+					case <-time.After(time.Duration(i) * time.Second):
+					}
+					if ctx.Err() != nil {
+						break
+					}
+				}
+
+				stage.lock.Lock()
+				req := buildTranslateRequest(stage, target)
+				stage.lock.Unlock()
+
+				if translated, err = translator.Translate(ctx, req); err == nil {
+					break
+				}
+			}
+			if err == nil && ctx.Err() != nil {
+				err = ctx.Err()
+			}
+
+			stage.lock.Lock()
+			defer stage.lock.Unlock()
+
+			// The segment may be merged or split while translating.
+			if err == nil && stage.asrOutputObject.QuerySegment(target.UUID) != target {
+				err = errors.Errorf("segment %v is removed while translating", target.UUID)
+			}
+			if err == nil {
+				before := copySegments(target)
+				target.Translated = translated
+				target.TranslatedAt = AITime(time.Now())
+				target.TranslatedBy = engine
+				if err = stage.asrOutputObject.Save(stage.asrOutputJSON); err == nil {
+					err = stage.Record(OperationTranslate, engine, before, copySegments(target))
+				}
+			}
+			job.Progress(target.UUID, err)
+			job.Logf(ctx, "Translate %v by %v, resp is <%v>B, err %v", target.UUID, engine, len(translated), err)
+		}(segment)
+	}
+	wg.Wait()
+
+	return nil
+}
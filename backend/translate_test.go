@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTranslateAllCancelRetry(t *testing.T) {
+	setupTestServer(t)
+
+	// The translate engine always fails, so the segment is retried.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	t.Setenv("VODT_TRANSLATOR", "openai-compatible")
+	t.Setenv("VODT_TRANSLATE_BASE_URL", server.URL)
+
+	sid := uuid.NewString()
+	importTestSubtitle(t, sid, 1)
+	stage := translatorServer.QueryStage(sid)
+	segments := stage.CopySegments()
+
+	// Cancel the job, which should never wait out the backoff of retries.
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	starttime := time.Now()
+	job := NewJob(sid, "translate", len(segments))
+	doTranslateAll(ctx, stage, job, segments, &TranslateAllOptions{Concurrency: 1, Retry: 5})
+	if d := time.Since(starttime); d > 3*time.Second {
+		t.Errorf("cancel the job in %v", d)
+	}
+	if job.Snapshot().Failed != 1 {
+		t.Errorf("job is %+v", job.Snapshot())
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ossrs/go-oryx-lib/errors"
+	"github.com/ossrs/go-oryx-lib/logger"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TTSProvider converts the text to speech, and writes to an audio file.
+type TTSProvider interface {
+	// Synthesize the text to the audio file.
+	Synthesize(ctx context.Context, text, filename string) error
+	// Container is the file extension of output audio, for example, aac or mp3.
+	Container() string
+	// ContentType is the MIME type of output audio, for example, audio/aac.
+	ContentType() string
+}
+
+// The registry of TTS providers, the key is the name of VODT_TTS_PROVIDER.
+var ttsProviders = make(map[string]TTSProvider)
+var ttsProvidersLock sync.Mutex
+
+// RegisterTTSProvider register the TTS provider by name.
+func RegisterTTSProvider(name string, provider TTSProvider) {
+	ttsProvidersLock.Lock()
+	defer ttsProvidersLock.Unlock()
+
+	ttsProviders[name] = provider
+}
+
+// QueryTTSProvider returns the TTS provider by name, nil if not found.
+func QueryTTSProvider(name string) TTSProvider {
+	ttsProvidersLock.Lock()
+	defer ttsProvidersLock.Unlock()
+
+	return ttsProviders[name]
+}
+
+// QueryTTSContentType returns the MIME type of TTS file, by its provider or by its extension.
+func QueryTTSContentType(name, filename string) string {
+	if provider := QueryTTSProvider(name); provider != nil {
+		return provider.ContentType()
+	}
+
+	ttsProvidersLock.Lock()
+	defer ttsProvidersLock.Unlock()
+
+	for _, provider := range ttsProviders {
+		if path.Ext(filename) == "."+provider.Container() {
+			return provider.ContentType()
+		}
+	}
+	return ""
+}
+
+// Whether the segment should be converted to speech, if translated after the last TTS.
+func shouldTTS(target *AudioSegment) bool {
+	if target.Removed || target.Text == "" || target.Translated == "" {
+		return false
+	}
+	return target.TTS == "" || target.TTSDuration <= 0 || time.Time(target.TranslatedAt).After(time.Time(target.TTSAt))
+}
+
+// The concurrency limiters of TTS providers, shared by all jobs, see VODT_TTS_CONCURRENCY.
+var ttsLimiters = make(map[string]chan bool)
+var ttsLimitersLock sync.Mutex
+
+// Get the concurrency limiter of TTS provider, the VODT_TTS_CONCURRENCY is a list of limits like
+// openai=3,11labs=2,local=1, default to 1.
+func queryTTSLimiter(name string) chan bool {
+	ttsLimitersLock.Lock()
+	defer ttsLimitersLock.Unlock()
+
+	if limiter, ok := ttsLimiters[name]; ok {
+		return limiter
+	}
+
+	limit := 1
+	for _, item := range strings.Split(os.Getenv("VODT_TTS_CONCURRENCY"), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(item), "=", 2); len(kv) == 2 && kv[0] == name {
+			if v, err := strconv.Atoi(kv[1]); err == nil && v > 0 {
+				limit = v
+			}
+		}
+	}
+
+	limiter := make(chan bool, limit)
+	ttsLimiters[name] = limiter
+	return limiter
+}
+
+func init() {
+	RegisterTTSProvider("openai", &openaiTTSProvider{})
+	RegisterTTSProvider("11labs", &elevenLabsTTSProvider{})
+	RegisterTTSProvider("local", &localTTSProvider{})
+}
+
+// Write the TTS audio from reader to file.
+func writeTTSFile(filename string, r io.Reader) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return errors.Errorf("Unable to create the file %v for writing", filename)
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, r); err != nil {
+		return errors.Errorf("Error writing the file")
+	}
+	return nil
+}
+
+// The TTS by OpenAI speech API.
+type openaiTTSProvider struct {
+}
+
+func (v *openaiTTSProvider) Container() string {
+	return "aac"
+}
+
+func (v *openaiTTSProvider) ContentType() string {
+	return "audio/aac"
+}
+
+func (v *openaiTTSProvider) Synthesize(ctx context.Context, text, filename string) error {
+	client := openai.NewClientWithConfig(aiConfig)
+	resp, err := client.CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model:          openai.TTSModel1,
+		Input:          text,
+		Voice:          openai.VoiceNova,
+		ResponseFormat: openai.SpeechResponseFormatAac,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "create speech")
+	}
+	defer resp.Close()
+
+	return writeTTSFile(filename, resp)
+}
+
+// The TTS by ElevenLabs API, see VODT_11LABS_KEY and VODT_11LABS_VOICE.
+type elevenLabsTTSProvider struct {
+}
+
+func (v *elevenLabsTTSProvider) Container() string {
+	return "mp3"
+}
+
+func (v *elevenLabsTTSProvider) ContentType() string {
+	return "audio/mpeg"
+}
+
+func (v *elevenLabsTTSProvider) Synthesize(ctx context.Context, text, filename string) error {
+	url := fmt.Sprintf("https://api.elevenlabs.io/v1/text-to-speech/%v", os.Getenv("VODT_11LABS_VOICE"))
+
+	data := map[string]string{
+		"text": text,
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return errors.Errorf("Unable to marshal the data")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Errorf("Unable to create the request")
+	}
+
+	req.Header.Set("Accept", "audio/mpeg")
+	req.Header.Add("xi-api-key", os.Getenv("VODT_11LABS_KEY"))
+	req.Header.Add("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Errorf("Unable to send the request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("Request %v failed, status=%v", url, res.StatusCode)
+	}
+
+	return writeTTSFile(filename, res.Body)
+}
+
+// The TTS by local piper or espeak-ng, see VODT_LOCAL_TTS, to dub offline.
+type localTTSProvider struct {
+}
+
+func (v *localTTSProvider) Container() string {
+	return "wav"
+}
+
+func (v *localTTSProvider) ContentType() string {
+	return "audio/wav"
+}
+
+func (v *localTTSProvider) Synthesize(ctx context.Context, text, filename string) error {
+	var cmd *exec.Cmd
+	if os.Getenv("VODT_LOCAL_TTS") == "piper" {
+		cmd = exec.CommandContext(ctx, os.Getenv("VODT_PIPER_BIN"),
+			"--model", os.Getenv("VODT_PIPER_MODEL"), "--output_file", filename,
+		)
+	} else if os.Getenv("VODT_LOCAL_TTS") == "espeak-ng" {
+		cmd = exec.CommandContext(ctx, os.Getenv("VODT_ESPEAK_BIN"),
+			"-v", os.Getenv("VODT_ESPEAK_VOICE"), "-w", filename, "--stdin",
+		)
+	} else {
+		return errors.Errorf("Unknown local TTS %v", os.Getenv("VODT_LOCAL_TTS"))
+	}
+
+	// Feed the text by stdin, never by arguments, because text may starts with dash.
+	cmd.Stdin = strings.NewReader(text)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "exec %v, output is %v", cmd.String(), string(b))
+	}
+	return nil
+}
+
+// Convert all segments to speech, in parallel limited by the concurrency of TTS provider. Each
+// segment is saved once converted, and the job is saved in project, to resume after restart.
+func doTTSAll(ctx context.Context, stage *Project, job *Job, segments []*AudioSegment) error {
+	limiter := queryTTSLimiter(os.Getenv("VODT_TTS_PROVIDER"))
+
+	var wg sync.WaitGroup
+	for _, segment := range segments {
+		wg.Add(1)
+		limiter <- true
+		go func(target *AudioSegment) {
+			defer wg.Done()
+			defer func() { <-limiter }()
+
+			tmp, err := processSegmentCopy(stage, target.UUID, func(tmp *AudioSegment) error {
+				if err := doTTS(ctx, stage, tmp); err != nil {
+					return err
+				}
+				return detectTTS(ctx, stage, tmp)
+			}, func(target, tmp *AudioSegment) {
+				target.TTS, target.TTSAt, target.TTSProvider = tmp.TTS, tmp.TTSAt, tmp.TTSProvider
+				target.TTSDuration = tmp.TTSDuration
+			})
+
+			job.Progress(target.UUID, err)
+			job.Logf(ctx, "TTS %v, duration=%v, err %v", target.UUID, tmp.TTSDuration, err)
+		}(segment)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// Resume the TTS jobs of project, which are running when server restart. The other running jobs
+// can not be resumed, so they are failed.
+func resumeTTSJobs(ctx context.Context, stage *Project) error {
+	jobs, err := loadJobs(stage.MainDir)
+	if err != nil {
+		return errors.Wrapf(err, "load jobs")
+	}
+
+	for _, job := range jobs {
+		if job.State != JobRunning {
+			continue
+		}
+
+		// Fail the job which is interrupted by restart.
+		if job.Kind != "tts" {
+			job.dir = stage.MainDir
+			job.update(func() {
+				job.State, job.Error = JobFailed, "interrupted by restart"
+			})
+			logger.Tf(ctx, "Fail %v job %v, interrupted by restart", job.Kind, job.ID)
+			continue
+		}
+
+		// The stage is published, so query the segments with lock.
+		var segments []*AudioSegment
+		for _, segment := range stage.CopySegments() {
+			if shouldTTS(segment) {
+				segments = append(segments, segment)
+			}
+		}
+
+		job.SetTotal(job.Done+len(segments), job.Done)
+		logger.Tf(ctx, "Resume TTS job %v, done=%v, segments=%v", job.ID, job.Done, len(segments))
+
+		translatorServer.jobs.Resume(ctx, stage, job, func(job *Job) error {
+			return doTTSAll(ctx, stage, job, segments)
+		})
+	}
+	return nil
+}